@@ -27,7 +27,7 @@ func LintAmbiguousLoopTermination(t *testing.T) {
 		n += len(lines)
 	}
 	if n > 0 {
-		t.Errorf("Detected %d ambiguously terminated loops. Replace with invariant.Until or invariant.GameLoop\n", n)
+		t.Errorf("Detected %d ambiguously terminated loops. Range over invariant.Until, invariant.UntilDeadline, invariant.UntilProgress, invariant.GameLoop or the sim retry helpers instead\n", n)
 		for file, lines := range loops {
 			for _, line := range lines {
 				t.Errorf("\t%s:%d\n", file, line)
@@ -41,7 +41,7 @@ func findAmbiguousLoopTerminations(files []string) map[string][]int {
 
 	locations := make(map[string][]int)
 	for _, file := range files {
-		invariant.Ensure(filepath.Ext(file) == ".go", "File to parse is Go source code")
+		invariant.Ensure(filepath.Ext(file) == ".go", "File to parse is Go source code") //invariant:loop
 		waitGroup.Add(1)
 
 		go func(file string) {
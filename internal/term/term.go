@@ -0,0 +1,43 @@
+// Package term holds the terminal escape sequences shared by the packages and commands of this
+// module so that their output looks the same.
+package term
+
+import (
+	"io"
+	"os"
+)
+
+const (
+	// Set this environment variable to any non-empty value to disable colored output.
+	// Reference: https://no-color.org
+	NoColorEnv = "NO_COLOR"
+
+	Reset   = "\033[0m"
+	Dim     = "\033[2m"
+	Red     = "\033[31m"
+	Green   = "\033[32m"
+	Yellow  = "\033[33m"
+	Magenta = "\033[35m"
+	Cyan    = "\033[36m"
+)
+
+// IsTerminal reports whether writer is a character device. This is a heuristic that doesn't
+// distinguish terminals from other character devices such as /dev/null, which is good enough to
+// keep escape sequences out of files and pipes.
+func IsTerminal(writer io.Writer) bool {
+	file, ok := writer.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// IsColored reports whether output to writer should be colored: it is a terminal and NoColorEnv
+// is unset.
+func IsColored(writer io.Writer) bool {
+	return IsTerminal(writer) && os.Getenv(NoColorEnv) == ""
+}
@@ -3,7 +3,9 @@
 package invariant
 
 import (
+	"cmp"
 	"iter"
+	"testing"
 )
 
 func registerAssertion() {
@@ -15,21 +17,39 @@ func RegisterPackagesForAnalysis(dirs ...string) {
 func AnalyzeAssertionFrequency() {
 }
 
+func analyzeAssertionFrequency(options TestOptions) TestResult {
+	return TestResult{}
+}
+
+func WriteAssertionReport(dir string) (path string, err error) {
+	return "", nil
+}
+
+func writeTestReport(dir string, options TestOptions) (path string, err error) {
+	return "", nil
+}
+
+func RegisterAssertionTable(sites []AssertionSite) {
+}
+
+func AttributeTest(t testing.TB) {
+}
+
 type _Number interface {
 	~int | ~int8 | ~int16 | ~int32 | ~int64 |
 		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64
 }
 
-func Until[T _Number](_ T) iter.Seq[T] {
-	return func(yield func(int) bool) {
-		iteration := 0
-		for {
-			if !yield(iteration) {
-				return
-			}
-			iteration++
-		}
-	}
+func Until[T _Number](_ T) iter.Seq[int] {
+	return GameLoop()
+}
+
+func UntilDeadline[T _Number](_ T, _ func() T) iter.Seq[int] {
+	return GameLoop()
+}
+
+func UntilProgress[T cmp.Ordered](_ func() T, _ int) iter.Seq[int] {
+	return GameLoop()
 }
 
 func Unreachable(msg string) {
@@ -44,6 +64,15 @@ func Sometimes(ok bool, msg string) {
 func Always(cond bool, msg string) {
 }
 
+func Reachable(msg string) {
+}
+
+func SometimesCount(cond bool, min int, msg string) {
+}
+
+func ProbablyRatio(cond bool, lo, hi float64, msg string) {
+}
+
 func AlwaysNil(x any, msg string) {
 }
 
@@ -16,6 +16,15 @@ encode and enforce assumptions (invariants) directly within your code.
      Observing its absence requires a program context where all expected states
      to be consistently exercised—which is only feasible in testing environments.
 
+   - **Reachable**, **SometimesCount** and **ProbablyRatio** — Variants of
+     Sometimes. Reachable marks a code path that must run. SometimesCount must be
+     true a minimum number of times and ProbablyRatio must be true in a fraction
+     of its evaluations. The analyzer enforces these thresholds at the end of the run.
+
+   - **Temporal properties** — AlwaysBefore, NeverAfter and EventuallyAfter
+     constrain the order of named events emitted on a Timeline, such as "Close is
+     never called twice". They are checked on every Emit.
+
 # Implementation
 
 In production, assertions panic/crash on violation.
@@ -34,6 +43,21 @@ runtime. Any assertion that never evaluated to true (frequency = 0) is reported
 as a “missed invariant,” causing the test suite to fail. If all assertions were
 exercised, the analyzer prints a summary showing the *least exercised* assertions.
 
+Set INVARIANT_REPORT_DIR to persist the tracker of every test run as a JSON Report.
+Reports of different packages or CI shards can then be merged with `go run
+./invariant/cmd/invariant merge` so that an assertion exercised anywhere counts as
+covered, and `invariant check` runs the missed-invariant analysis over the result.
+`invariant view` overlays the frequencies on the source, either in the terminal or as
+an HTML page, similar to `go tool cover -html`. Tests that call AttributeTest are
+recorded per assertion so that `invariant tests` can answer which tests exercise an
+assertion and which assertions only one test exercises. `invariant diff` compares two
+reports, such as the ones of a refactor and its base commit, and fails if assertions lost
+their coverage.
+
+Outside of tests, SetTelemetry samples the assertions that real traffic exercises and
+periodically writes them in the same Report format, so production coverage can be compared
+with the tests.
+
 Invariant therefore provides actionable, frequency-based insight into how
 thoroughly your properties have been exercised, revealing the true scope and
 effectiveness of your testing suite. By tracking which invariants fire and
@@ -48,47 +72,125 @@ behavior and constraints of the system.
 package invariant
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
-	"go/ast"
 	"go/parser"
 	"go/token"
 	"iter"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
-	"sort"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
 )
 
 const (
 	// This library minimizes heap allocations by preferring fixed-size backing arrays for
 	// slices. These values determine the size of those arrays and should accommodate typical
 	// codebases without frequent resizing.
-	leastExercisedInvariantCount = 10
-	// maxAssertionsPerPackage is the maximum number of Sometimes, XSometimes, Always*,
-	// XAlways*, and Ensure calls in a single package.
-	maxAssertionsPerPackage = 2048
-	maxGoFilesPerPackage    = 1024
-	maxFilePath             = 260
-	maxFileLines            = 5 // In digits (99,999 lines)
-	assertionIDLength       = maxFilePath + 1 + maxFileLines
+	maxFilePath       = 260
+	maxFileLines      = 5 // In digits (99,999 lines)
+	assertionIDLength = maxFilePath + 1 + maxFileLines
+	// maxAssertionCallers is the capacity of assertionCallers. It must be a power of two.
+	// Call sites beyond this are still tracked but through the slow path.
+	maxAssertionCallers = 8192
 )
 
 var (
 	// packagesToAnalyze defaults to the current testing package.
 	packagesToAnalyze = []string{"."}
-	// assertionTracker globally tracks true assertions inside packagesToAnalyze.
-	assertionTracker        = make(map[string]*metadata, maxAssertionsPerPackage*len(packagesToAnalyze))
+	// assertionTracker globally tracks true assertions inside packagesToAnalyze, keyed by
+	// file:line. Entries are only added during registration, before the tests run. Afterwards,
+	// only their atomic counters change.
+	assertionTracker        = make(map[string]*metadata)
 	assertionFrequencyMutex = sync.Mutex{}
+
+	// assertionCallers is a lock-free cache from the program counter of an assertion call to
+	// its tracker entry. It is an open-addressing hash table that is only ever inserted into,
+	// so registerAssertion resolves each call site once instead of on every evaluation.
+	assertionCallers [maxAssertionCallers]struct {
+		PC       atomic.Uintptr
+		Metadata atomic.Pointer[metadata]
+	}
+	// untrackedAssertion is cached for call sites that aren't in assertionTracker.
+	untrackedAssertion = &metadata{}
+
+	// currentTest is the name of the latest test that called AttributeTest and is still running.
+	currentTest atomic.Pointer[string]
 )
 
 type metadata struct {
-	Frequency int
+	ID        uint64
+	Frequency atomic.Int64
 	Message   string
 	Kind      string
+	// IsAnalyzed is false for entries that were only registered through
+	// RegisterAssertionTable. These are tracked but excluded from the analysis and reports
+	// unless their package is also registered for analysis.
+	IsAnalyzed bool
+	// Tests is the set of attributed tests that evaluated the assertion as true. It is guarded
+	// by assertionFrequencyMutex.
+	Tests map[string]bool
+	// LastTest avoids taking the mutex when the same test evaluates an assertion repeatedly.
+	LastTest atomic.Pointer[string]
+
+	// Thresholds of SometimesCount and ProbablyRatio. They are stored on every evaluation since
+	// they aren't known until then.
+	MinFrequency atomic.Int64
+	RatioLo      atomic.Uint64 // float64 bits
+	RatioHi      atomic.Uint64 // float64 bits
+	// Evaluations counts both true and false evaluations of ProbablyRatio.
+	Evaluations atomic.Int64
+}
+
+// hit counts a true evaluation and attributes it to the current test.
+func (a *metadata) hit() {
+	if a == untrackedAssertion {
+		return
+	}
+	a.Frequency.Add(1)
+	test := currentTest.Load()
+	if test == nil || a.LastTest.Swap(test) == test {
+		return
+	}
+	assertionFrequencyMutex.Lock()
+	if a.Tests == nil {
+		a.Tests = make(map[string]bool)
+	}
+	a.Tests[*test] = true
+	assertionFrequencyMutex.Unlock()
+}
+
+// sampled counts weight true evaluations that were sampled by the telemetry.
+func (a *metadata) sampled(weight int64) {
+	if a != untrackedAssertion {
+		a.Frequency.Add(weight)
+	}
+}
+
+// AttributeTest attributes every assertion that evaluates to true until t finishes to t, which
+// lets reports list the tests that exercised each assertion. Call it at the start of a test:
+//
+//	func TestFoo(t *testing.T) {
+//		invariant.AttributeTest(t)
+//		...
+//	}
+//
+// Subtests that call it take over until they finish. Attribution is global so parallel tests are
+// attributed to whichever of them called AttributeTest last.
+func AttributeTest(t testing.TB) {
+	name := t.Name()
+	previous := currentTest.Swap(&name)
+	t.Cleanup(func() {
+		currentTest.CompareAndSwap(&name, previous)
+	})
 }
 
 // registerAssertion records in the package-global assertion tracker that an
@@ -96,18 +198,80 @@ type metadata struct {
 // file and line number of the call and increments a counter in
 // assertionTracker.
 //
-// This function is a noop outside of a test environment.
+// It is concurrency-safe and can be called from multiple goroutines. Only the
+// first evaluation of a call site takes the mutex. Afterwards, the entry is found
+// through assertionCallers.
 //
-// It is concurrency-safe and can be called from multiple goroutines.
+// Outside of tests, it only counts the evaluations that are sampled by the Telemetry.
 //
 //go:noinline
 func registerAssertion() {
-	if IsRunningUnderGoBenchmark || IsRunningUnderGoFuzz {
-		return
+	if IsRunningUnderGoTest {
+		callerAssertion(4).hit()
+	} else if weight := sampleTelemetry(); weight > 0 {
+		callerAssertion(4).sampled(weight)
 	}
+}
+
+// callerAssertion returns the tracker entry of the assertion call that is skip frames up the stack,
+// as counted by runtime.Callers. Untracked call sites return untrackedAssertion.
+//
+//go:noinline
+func callerAssertion(skip int) *metadata {
 	callers := [1]uintptr{}
-	count := runtime.Callers(3, callers[:])
-	frame, _ := runtime.CallersFrames(callers[:count]).Next()
+	if runtime.Callers(skip, callers[:]) == 0 {
+		return untrackedAssertion
+	}
+	return assertionAt(callers[0], "", skip+1)
+}
+
+// callerPC returns the program counter of the call that is skip frames up the stack, as counted by
+// runtime.Callers, so that its tracker entry can be resolved later through assertionAt.
+//
+//go:noinline
+func callerPC(skip int) uintptr {
+	callers := [1]uintptr{}
+	if runtime.Callers(skip, callers[:]) == 0 {
+		return 0
+	}
+	return callers[0]
+}
+
+// assertionAt returns the tracker entry of the call site at pc. The telemetry names the entry
+// after kind or, if kind is empty, after the assertion function that is skip frames up the stack
+// of the caller of assertionAt.
+func assertionAt(pc uintptr, kind string, skip int) *metadata {
+	if pc == 0 {
+		return untrackedAssertion
+	}
+	// Fibonacci hashing spreads the program counters across the table.
+	start := int((uint64(pc) * 11400714819323198485) >> (64 - 13))
+	for probe := range maxAssertionCallers {
+		slot := &assertionCallers[(start+probe)&(maxAssertionCallers-1)]
+		switch slot.PC.Load() {
+		case pc:
+			if a := slot.Metadata.Load(); a != nil {
+				return a
+			}
+			// Another goroutine is still resolving this call site.
+			return resolveAssertion(pc, kind, skip)
+		case 0:
+			a := resolveAssertion(pc, kind, skip)
+			// If another call site wins the slot, the next evaluation probes further.
+			if slot.PC.CompareAndSwap(0, pc) {
+				slot.Metadata.Store(a)
+			}
+			return a
+		}
+	}
+	return resolveAssertion(pc, kind, skip)
+}
+
+// resolveAssertion looks up the tracker entry of the call site. Call sites that aren't tracked
+// resolve to untrackedAssertion, which ignores hits, unless the telemetry is enabled outside of
+// tests.
+func resolveAssertion(pc uintptr, kind string, skip int) *metadata {
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
 
 	arr := [assertionIDLength]byte{}
 	buf := arr[:0]
@@ -115,24 +279,63 @@ func registerAssertion() {
 	buf = append(buf, ':')
 	buf = strconv.AppendInt(buf, int64(frame.Line), 10)
 
-	id := string(buf)
 	assertionFrequencyMutex.Lock()
-	a, ok := assertionTracker[id]
+	defer assertionFrequencyMutex.Unlock()
+	a, ok := assertionTracker[string(buf)]
 	if ok {
-		a.Frequency++
+		return a
+	}
+	if !IsRunningUnderGoTest && telemetrySampleEvery.Load() > 0 {
+		return telemetryAssertion(string(buf), kind, skip)
+	}
+	return untrackedAssertion
+}
+
+// resetAssertionCallers must be called whenever assertionTracker changes since call sites that
+// were untracked may now be tracked.
+func resetAssertionCallers() {
+	for i := range assertionCallers {
+		assertionCallers[i].Metadata.Store(nil)
+		assertionCallers[i].PC.Store(0)
+	}
+}
+
+// RegisterAssertionTable is called by the init function generated by `invariant generate`. Sites
+// are relative to the directory of the generated file. The assertions are tracked without parsing
+// the package, but they are only analyzed if the package is also registered for analysis.
+func RegisterAssertionTable(sites []AssertionSite) {
+	_, generated, _, ok := runtime.Caller(1)
+	Ensure(ok, "RegisterAssertionTable knows the location of the generated table")
+	dir := filepath.Dir(generated)
+
+	assertionFrequencyMutex.Lock()
+	for _, site := range sites {
+		location := filepath.Join(dir, site.File) + ":" + strconv.Itoa(site.Line)
+		if _, ok := assertionTracker[location]; ok {
+			continue
+		}
+		assertionTracker[location] = &metadata{ID: site.ID, Kind: site.Kind, Message: site.Message}
 	}
 	assertionFrequencyMutex.Unlock()
+	resetAssertionCallers()
 }
 
 // RegisterPackagesForAnalysis ensures that only assertions from the tested
 // directories are tracked for frequency analysis. Dirs is relative to the
 // directory of the caller.
 //
+// Like the go command, "./..." includes every package below the directory, which lets a
+// whole-service simulation track all of its assertions. Import paths inside the module of
+// the working directory, such as "example.com/service/...", are resolved through its go.mod.
+// Only the files of the current build are parsed, so files excluded by the -tags of the test
+// binary, such as disable_assertions or fault_injection variants, are skipped.
+//
 // NOTE: All assertions must have their last parameter be the message parameter
 //
-// NOTE: During fuzzing or benchmarking, assertion tracking is disabled because
-// worker processes handle assertions in separate memory spaces. Any registrations
-// done there are not synchronized back to the parent test runner
+// NOTE: During fuzzing, the fuzz workers are separate processes that run TestMain themselves.
+// The parent creates a shared directory for them here. Each worker writes its tracker there as a
+// Report when it exits, which the parent merges before writing its own report or analyzing.
+// Workers that crash or get killed lose their frequencies.
 //
 // NOTE: Tracking assertions during fuzzing can help gauge how thoroughly your fuzzer exercises the code.
 // However, since this implementation tracks assertions at the package level—and most fuzz tests
@@ -140,110 +343,257 @@ func registerAssertion() {
 // useful when simulating the entire program; in that case, using a dedicated script, unit test,
 // or custom fuzz harness is more appropriate.
 //
-// PERF: Create an instrumentation tool that hardcodes assertion source location, drastically
-// improving registration performance at runtime. This enables assertion tracking in fuzz testing.
+// NOTE: Every assertion gets a static ID from ScanAssertions which is written to the reports so
+// that they survive unrelated line shifts. Packages that aren't analyzed can still be tracked
+// without parsing them by generating an assertion table with `invariant generate`.
 func RegisterPackagesForAnalysis(dirs ...string) {
 	Always(IsRunningUnderGoTest, "RegisterPackagesForAnalysis is only used in testing environments")
 	Always(len(packagesToAnalyze) == 1 && packagesToAnalyze[0] == ".", "packagesToAnalyze was set to the current testing package by default")
-	if IsRunningUnderGoBenchmark || IsRunningUnderGoFuzz {
-		return
+	if IsRunningUnderGoFuzz && !IsRunningUnderGoFuzzWorker {
+		dir, err := os.MkdirTemp("", "invariant-fuzz-*")
+		if err != nil {
+			panic(fmt.Sprintf("Creating the directory shared with fuzz workers: %s\n", err))
+		}
+		// Workers inherit the environment of the coordinator which is captured during m.Run.
+		os.Setenv(fuzzWorkerReportDirEnv, dir)
 	}
+	patterns := packagesToAnalyze
 	if len(dirs) > 0 {
-		packagesToAnalyze = dirs
-	}
-	// === Absolute Path Conversion ===
-	for i, path := range packagesToAnalyze {
-		path, err := filepath.Abs(path)
-		if err != nil || path == "" {
-			panic(fmt.Sprintf("Failed to convert package path to absolute path: %s\n", err))
-		}
-		packagesToAnalyze[i] = path
+		patterns = dirs
 	}
 
 	// ===Collection===
-	filesArray := [maxGoFilesPerPackage]string{}
-	files := filesArray[:0]
-	for _, dir := range packagesToAnalyze {
+	// packagesToAnalyze becomes the absolute directories of every matched package.
+	var files []string
+	packagesToAnalyze = nil
+	context := assertionBuildContext()
+	for _, pattern := range patterns {
+		matched, err := expandPackagePattern(pattern)
+		if err != nil {
+			panic(fmt.Sprintf("Resolving %s: %s\n", pattern, err))
+		}
 		before := len(files)
-		err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
-			if err != nil || d.IsDir() || filepath.Ext(path) != ".go" {
-				return err
-			}
-			path, err = filepath.Abs(path)
+		for _, dir := range matched {
+			matchedFiles, err := packageFiles(context, dir)
 			if err != nil {
-				return err
+				panic(fmt.Sprintf("Collecting all files to find missed invariants: %s\n", err))
 			}
-			if d.IsDir() && path != dir {
-				return filepath.SkipDir
+			files = append(files, matchedFiles...)
+			if len(matchedFiles) > 0 && !slices.Contains(packagesToAnalyze, dir) {
+				packagesToAnalyze = append(packagesToAnalyze, dir)
 			}
-			if len(path) > len("_test.go") && strings.HasSuffix(path, "_test.go") {
-				return nil
-			}
-			files = append(files, path)
-			return nil
-		})
-		if err != nil {
-			panic(fmt.Sprintf("Collecting all files to find missed invariants: %s\n", err))
 		}
 		after := len(files)
-		Always(before < after, "The directory contains go files")
+		Always(before < after, "The pattern matches go files")
 	}
 	Always(len(files) > 0, "There's at least one file to parse")
 
 	// ===Parsing===
+	// IDs are relative to the module of each package, which may differ when patterns span
+	// several modules.
+	packagePaths := make(map[string]string, len(packagesToAnalyze))
+	for _, dir := range packagesToAnalyze {
+		root, _, err := FindModule(dir)
+		if err != nil {
+			panic(fmt.Sprintf("Finding the module of %s: %s\n", dir, err))
+		}
+		pkg, err := filepath.Rel(root, dir)
+		if err != nil {
+			pkg = dir
+		}
+		packagePaths[dir] = filepath.ToSlash(pkg)
+	}
 	semaphore := make(chan struct{}, runtime.NumCPU())
 	var wg sync.WaitGroup
 
+	// Generated tables of the analyzed packages are superseded by the source since they may
+	// be stale.
+	assertionFrequencyMutex.Lock()
+	for location := range assertionTracker {
+		file, _ := splitLocation(location)
+		if slices.Contains(packagesToAnalyze, filepath.Dir(file)) {
+			delete(assertionTracker, location)
+		}
+	}
+	assertionFrequencyMutex.Unlock()
+
 	for _, path := range files {
 		wg.Add(1)
 		semaphore <- struct{}{}
 		go func(path string) {
 			defer func() { <-semaphore; wg.Done() }()
 			fset := token.NewFileSet()
-			node, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+			node, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
 			if err != nil {
 				return
 			}
-			ast.Inspect(node, func(n ast.Node) bool {
-				call, ok := n.(*ast.CallExpr)
-				if !ok {
-					return true
-				}
-				sel, ok := call.Fun.(*ast.SelectorExpr)
-				if !ok {
-					return true
+			sites, err := ScanAssertions(fset, node, packagePaths[filepath.Dir(path)])
+			if err != nil {
+				panic(fmt.Sprintf("Registering assertions: %s\n", err))
+			}
+			assertionFrequencyMutex.Lock()
+			for _, site := range sites {
+				assertionTracker[site.File+":"+strconv.Itoa(site.Line)] = &metadata{
+					ID:         site.ID,
+					Kind:       site.Kind,
+					Message:    site.Message,
+					IsAnalyzed: true,
 				}
-				ident, ok := sel.X.(*ast.Ident)
-				if !ok {
-					return true
+			}
+			assertionFrequencyMutex.Unlock()
+		}(path)
+	}
+
+	wg.Wait()
+	resetAssertionCallers()
+}
+
+// WriteAssertionReport persists the assertion tracker into a new file inside dir. Locations are
+// made relative to the module root of the first package registered for analysis.
+//
+// Fuzz workers don't write reports since their frequencies are merged into the parent's.
+func WriteAssertionReport(dir string) (path string, err error) {
+	return writeTestReport(dir, TestOptions{})
+}
+
+// writeTestReport is WriteAssertionReport with the thresholds of options stored in the report.
+func writeTestReport(dir string, options TestOptions) (path string, err error) {
+	Always(IsRunningUnderGoTest, "WriteAssertionReport is only used for testing")
+	Always(len(packagesToAnalyze) > 0, "At least one package was registered for analysis")
+	if IsRunningUnderGoFuzzWorker {
+		return "", nil
+	}
+	if err := syncFuzzWorkers(); err != nil {
+		return "", err
+	}
+	return writeAssertionReport(dir, options)
+}
+
+func writeAssertionReport(dir string, options TestOptions) (path string, err error) {
+	report, root, err := trackerReport()
+	if err != nil {
+		return "", err
+	}
+	report = options.applyTo(report)
+	// Named after the package so that the directory is easy to browse.
+	name := "root"
+	if rel, err := filepath.Rel(root, packagesToAnalyze[0]); err == nil && rel != "." {
+		name = strings.ReplaceAll(filepath.ToSlash(rel), "/", "_")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(dir, name+"-*.json")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if err := WriteReport(file, report); err != nil {
+		return "", err
+	}
+	return file.Name(), file.Close()
+}
+
+// trackerReport converts the assertion tracker into a Report whose locations are relative to
+// root, the module root of the first package registered for analysis.
+func trackerReport() (report Report, root string, err error) {
+	root, module, err := FindModule(packagesToAnalyze[0])
+	if err != nil {
+		return Report{}, "", fmt.Errorf("finding module of %s: %w", packagesToAnalyze[0], err)
+	}
+	report = Report{
+		Version:    ReportVersion,
+		Module:     module,
+		Assertions: make([]ReportEntry, 0, len(assertionTracker)),
+	}
+	assertionFrequencyMutex.Lock()
+	for location, metadata := range assertionTracker {
+		if !metadata.IsAnalyzed {
+			continue
+		}
+		file, line := splitLocation(location)
+		if rel, err := filepath.Rel(root, file); err == nil {
+			file = rel
+		}
+		report.Assertions = append(report.Assertions, metadata.reportEntry(filepath.ToSlash(file)+":"+strconv.Itoa(line)))
+	}
+	assertionFrequencyMutex.Unlock()
+	sortReportEntries(report.Assertions)
+	return report, root, nil
+}
+
+// reportEntry must be called while holding assertionFrequencyMutex.
+func (a *metadata) reportEntry(location string) ReportEntry {
+	return ReportEntry{
+		ID:           a.ID,
+		Location:     location,
+		Kind:         a.Kind,
+		Message:      a.Message,
+		Frequency:    int(a.Frequency.Load()),
+		Tests:        sortedTests(a.Tests),
+		Evaluations:  int(a.Evaluations.Load()),
+		MinFrequency: int(a.MinFrequency.Load()),
+		RatioLo:      math.Float64frombits(a.RatioLo.Load()),
+		RatioHi:      math.Float64frombits(a.RatioHi.Load()),
+	}
+}
+
+// syncFuzzWorkers exchanges trackers between fuzz workers and their parent through the directory
+// created by RegisterPackagesForAnalysis. Workers write their tracker while the parent adds the
+// frequencies of every worker report to its own tracker. The directory is deleted once merged so
+// that calling this more than once doesn't count them twice.
+func syncFuzzWorkers() error {
+	dir := os.Getenv(fuzzWorkerReportDirEnv)
+	if !IsRunningUnderGoFuzz || dir == "" {
+		return nil
+	}
+	if IsRunningUnderGoFuzzWorker {
+		// The parent applies the options to the merged tracker.
+		_, err := writeAssertionReport(dir, TestOptions{})
+		return err
+	}
+
+	root, _, err := FindModule(packagesToAnalyze[0])
+	if err != nil {
+		return fmt.Errorf("finding module of %s: %w", packagesToAnalyze[0], err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		report, err := ReadReport(file)
+		os.Remove(file)
+		if err != nil {
+			// The worker was most likely killed while writing.
+			fmt.Fprintf(os.Stderr, "Skipping fuzz worker report: %s\n", err)
+			continue
+		}
+		assertionFrequencyMutex.Lock()
+		for _, entry := range report.Assertions {
+			file, line := splitLocation(entry.Location)
+			location := filepath.Join(root, filepath.FromSlash(file)) + ":" + strconv.Itoa(line)
+			if a, ok := assertionTracker[location]; ok {
+				a.Frequency.Add(int64(entry.Frequency))
+				a.Evaluations.Add(int64(entry.Evaluations))
+				if entry.MinFrequency > 0 {
+					a.MinFrequency.Store(int64(entry.MinFrequency))
 				}
-				if ident.Name != "invariant" {
-					return true
+				if entry.Kind == "ProbablyRatio" && entry.Evaluations > 0 {
+					a.RatioLo.Store(math.Float64bits(entry.RatioLo))
+					a.RatioHi.Store(math.Float64bits(entry.RatioHi))
 				}
-				msg := ""
-				switch sel.Sel.Name {
-				case "Sometimes", "XSometimes", "Ensure", "Always", "AlwaysNil", "AlwaysErrIs", "AlwaysErrIsNot",
-					"XAlways", "XAlwaysNil", "XAlwaysErrIs", "XAlwaysErrIsNot":
-					pos := fset.Position(call.Lparen)
-					key := path + ":" + strconv.Itoa(pos.Line)
-					Always(len(call.Args) >= 2, "All assertions have at least two parameters")
-					literal, ok := call.Args[len(call.Args)-1].(*ast.BasicLit)
-					// TODO: include location
-					Always(ok, "The last parameter is the assertion message")
-					Always(literal.Kind == token.STRING, "The assertion message is a string literal")
-					msg = literal.Value[1 : len(literal.Value)-1] // remove quotes
-
-					assertionTracker[key] = &metadata{
-						Kind:    sel.Sel.Name,
-						Message: msg,
+				for _, test := range entry.Tests {
+					if a.Tests == nil {
+						a.Tests = make(map[string]bool)
 					}
+					a.Tests[test] = true
 				}
-				return true
-			})
-		}(path)
+			}
+		}
+		assertionFrequencyMutex.Unlock()
 	}
-
-	wg.Wait()
+	return os.RemoveAll(dir)
 }
 
 // AnalyzeAssertionFrequency scans the given directories for Sometimes, Always*,
@@ -259,110 +609,66 @@ func RegisterPackagesForAnalysis(dirs ...string) {
 //
 // It is critical that you import this package under the name "invariant" as it
 // is hardcoded in the analyzer to look for this identifier.
+//
+// Fuzzing and benchmarking only exercise a subset of the package so missed
+// invariants are reported without failing the run. Fuzz workers only hand their
+// tracker over to the parent.
+//
+// It exits with status 1 if the run fails. Use RunTests to configure the analysis and handle the
+// result instead.
 func AnalyzeAssertionFrequency() {
+	if result := analyzeAssertionFrequency(TestOptions{}); result.Code != 0 {
+		os.Exit(result.Code)
+	}
+}
+
+func analyzeAssertionFrequency(options TestOptions) TestResult {
 	Always(IsRunningUnderGoTest, "AnalyzeAssertionFrequency is only used for testing")
 	Always(len(packagesToAnalyze) > 0, "At least one package was registered for analysis")
-	if IsRunningUnderGoBenchmark || IsRunningUnderGoFuzz {
-		return
+	if err := syncFuzzWorkers(); err != nil {
+		fmt.Fprintf(os.Stderr, "Synchronizing fuzz workers: %s\n", err)
 	}
+	if IsRunningUnderGoFuzzWorker {
+		return TestResult{}
+	}
+	// Runs that write reports are gated by `invariant check` on the merged report instead, since an
+	// assertion only needs to be covered by one of the packages of `go test ./...`.
+	isMissFatal := !IsRunningUnderGoFuzz && !IsRunningUnderGoBenchmark && os.Getenv(ReportDirEnv) == ""
 
-	longestKindWord := 0
-	longestMessageLength := 0
-	missed := make([]string, 0, len(assertionTracker))
+	report := Report{Version: ReportVersion, Assertions: make([]ReportEntry, 0, len(assertionTracker))}
+	if _, module, err := FindModule(packagesToAnalyze[0]); err == nil {
+		report.Module = module
+	}
+	assertionFrequencyMutex.Lock()
 	for location, metadata := range assertionTracker {
-		Always(location != "", "All assertion records have a location")
-		if metadata.Frequency == 0 {
-			longestKindWord = max(longestKindWord, len(metadata.Kind))
-			longestMessageLength = max(longestMessageLength, len(metadata.Message))
-			missed = append(missed, location)
+		if metadata.IsAnalyzed {
+			report.Assertions = append(report.Assertions, metadata.reportEntry(location))
 		}
 	}
-	if len(missed) > 0 {
-		fmt.Printf("🚨 %d assertions were never true. 🚨\n", len(missed))
-		for _, id := range missed {
-			fmt.Printf(
-				"\t%*s | %-*s | %s\n",
-				longestKindWord, assertionTracker[id].Kind,
-				longestMessageLength, assertionTracker[id].Message,
-				id,
-			)
-		}
-		os.Exit(1)
-	}
-
-	// === Analysis ===
-	//
-	// This is a very simple and “dumb” analysis based solely on absolute
-	// assertion frequency. It does not attempt to correlate assertions with
-	// specific unit tests or cluster them with each other; any attempt to
-	// do so is futile. For that kind of analysis, you’d need
-	// instrumentation/static analysis combined with machine learning.
-	{
-		type scored struct {
-			key   string
-			count int
-		}
-		fmt.Printf("Showing up to %d of the least-exercised invariants:\n", leastExercisedInvariantCount)
-
-		longestMessageLength := 0
-		longestKindWord := 0
-
-		h := make([]scored, 0, leastExercisedInvariantCount)
-		for key, assertion := range assertionTracker {
-			Always(key != "", "Assertion location must not be empty")
-			longestMessageLength = max(longestMessageLength, len(assertion.Message))
-			longestKindWord = max(longestKindWord, len(assertion.Kind))
-
-			if len(h) < leastExercisedInvariantCount {
-				h = append(h, scored{key, assertion.Frequency})
-				if len(h) == leastExercisedInvariantCount {
-					sort.Slice(h, func(i, j int) bool {
-						return h[i].count > h[j].count
-					})
-				}
-				continue
-			}
-			if assertion.Frequency < h[0].count {
-				h[0] = scored{key, assertion.Frequency}
-				i := 0
-				for {
-					l, r := 2*i+1, 2*i+2
-					if l >= leastExercisedInvariantCount {
-						break
-					}
-					small := l
-					if r < leastExercisedInvariantCount && h[r].count > h[l].count {
-						small = r
-					}
-					if h[i].count >= h[small].count {
-						break
-					}
-					h[i], h[small] = h[small], h[i]
-					i = small
-				}
-			}
-		}
-
-		sort.Slice(h, func(i, j int) bool {
-			return h[i].count < h[j].count
-		})
-
-		for _, v := range h {
-			a := assertionTracker[v.key]
-			fmt.Printf(
-				"count=%-4d | %-*s | %-*s | %s\n",
-				a.Frequency,
-				longestKindWord, a.Kind,
-				longestMessageLength, a.Message,
-				v.key,
-			)
-		}
+	assertionFrequencyMutex.Unlock()
+	for _, entry := range report.Assertions {
+		Always(entry.Location != "", "All assertion records have a location")
+	}
+	sortReportEntries(report.Assertions)
+
+	// This is a very simple and “dumb” analysis based solely on absolute assertion frequency. It
+	// does not cluster assertions with each other. Correlating them with tests is left to the
+	// report, which lists the tests that called AttributeTest for every assertion.
+	result := AnalyzeReport(report, options, time.Now())
+	if result.Failed() && isMissFatal {
+		result.Code = 1
 	}
+	if err := WriteTestResult(testResultWriter(options), result, options.Format); err != nil {
+		fmt.Fprintf(os.Stderr, "Writing assertion analysis: %s\n", err)
+		result.Code = 1
+	}
+	return result
 }
 
 // Always calls assertionFailureCallback if cond is false.
 //
-// Note 1: If you need Always(item == nil), use AlwaysNil(item) instead.
+// Note 1: If you need Always(item == nil), use AlwaysNil(item) instead. Likewise,
+// prefer AlwaysEqual and the other comparison assertions so that the operands are logged.
 //
 // Note 2: When deferring assertions, enclose them in a closure. Otherwise, cond
 // is evaluated immediately.
@@ -394,13 +700,13 @@ func Always(cond bool, msg string) {
 	if cond {
 		registerAssertion()
 	} else {
-		assertionFailureCallback(fmt.Sprintf("%s: %s\n", AssertionFailureMsgPrefix, msg))
+		assertionFailureCallback(msg)
 	}
 }
 
 // Sometimes records that a condition was true at least once throughout the test
 // run. It only has an effect in test environments; outside of tests it is a
-// no-op.
+// no-op unless Telemetry is enabled.
 //
 // Technically, the term "invariant.Sometimes" is a misnomer. It's more accurately
 // described as a property check. But I wanted to name this library "invariant"
@@ -409,12 +715,73 @@ func Always(cond bool, msg string) {
 //
 //go:noinline
 func Sometimes(ok bool, msg string) {
-	if !IsRunningUnderGoTest || !ok {
+	if !ok {
 		return
 	}
 	registerAssertion()
 }
 
+// Reachable records that a code path was taken at least once throughout the test run. It is
+// the same as Sometimes(true, msg) but states the intent.
+//
+//go:noinline
+func Reachable(msg string) {
+	registerAssertion()
+}
+
+// SometimesCount is Sometimes except that cond must be true at least min times throughout the
+// test run. The analyzer enforces min at the end of the run.
+//
+//go:noinline
+func SometimesCount(cond bool, min int, msg string) {
+	if !IsRunningUnderGoTest {
+		if cond {
+			registerAssertion()
+		}
+		return
+	}
+	a := callerAssertion(3)
+	if a == untrackedAssertion {
+		return
+	}
+	a.MinFrequency.Store(int64(min))
+	if cond {
+		a.hit()
+	}
+}
+
+// ProbablyRatio records how often cond is true relative to how often it is evaluated. The
+// analyzer fails the run if the fraction of true evaluations falls outside of [lo, hi]. This
+// catches generators and fault injectors that are skewed, such as a branch that was meant to be
+// taken 10% of the time but is taken every time.
+//
+// An assertion that is never evaluated is reported as missed, even if lo is zero.
+//
+//go:noinline
+func ProbablyRatio(cond bool, lo, hi float64, msg string) {
+	// The negation also rejects NaN.
+	if !(0 <= lo && lo <= hi && hi <= 1) {
+		assertionFailureCallback(fmt.Sprintf("expected 0 <= lo <= hi <= 1. got [%v, %v]. %s", lo, hi, msg))
+		return
+	}
+	if !IsRunningUnderGoTest {
+		if cond {
+			registerAssertion()
+		}
+		return
+	}
+	a := callerAssertion(3)
+	if a == untrackedAssertion {
+		return
+	}
+	a.RatioLo.Store(math.Float64bits(lo))
+	a.RatioHi.Store(math.Float64bits(hi))
+	a.Evaluations.Add(1)
+	if cond {
+		a.hit()
+	}
+}
+
 // AlwaysNil calls assertionFailureCallback if x is NOT nil and prints the
 // non-null object. Prefer this over Always(x == nil) so that the value of x can
 // be logged.
@@ -424,7 +791,7 @@ func AlwaysNil(x interface{}, msg string) {
 	if x == nil {
 		registerAssertion()
 	} else {
-		assertionFailureCallback(fmt.Sprintf("%s: expected nil. got %v. %s\n", AssertionFailureMsgPrefix, x, msg))
+		assertionFailureCallback(fmt.Sprintf("expected nil. got %v. %s", x, msg))
 	}
 }
 
@@ -442,7 +809,7 @@ func AlwaysErrIs(actual error, targets []error, msg string) {
 			return
 		}
 	}
-	assertionFailureCallback(fmt.Sprintf("%s: error did not match any targets. got %q. %s\n", AssertionFailureMsgPrefix, actual, msg))
+	assertionFailureCallback(fmt.Sprintf("error did not match any targets. got %q. %s", actual, msg))
 }
 
 // AlwaysErrIsNot calls assertionFailureCallback if actual is one of the
@@ -455,7 +822,7 @@ func AlwaysErrIsNot(actual error, targets []error, msg string) {
 	for _, t := range targets {
 		Always(t != nil, "invariant.AlwaysErrIsNot() targets must not be nil")
 		if errors.Is(actual, t) {
-			assertionFailureCallback(fmt.Sprintf("%s: error unexpectedly matched a target. got %q. %s\n", AssertionFailureMsgPrefix, actual, msg))
+			assertionFailureCallback(fmt.Sprintf("error unexpectedly matched a target. got %q. %s", actual, msg))
 			return
 		}
 	}
@@ -506,12 +873,75 @@ func Until[T _Number](limit T) iter.Seq[int] {
 	}
 }
 
+// UntilDeadline is Until bounded by time instead of iterations. now reads a monotonic clock in the
+// unit of timeout. Prefer sim.UntilDeadline, which reads sim.Monotonic so that the deadline also
+// holds in simulations.
+//
+//	start := time.Now()
+//	for range invariant.UntilDeadline(time.Minute, func() time.Duration { return time.Since(start) }) {
+//		if ready() {
+//			break
+//		}
+//	}
+//
+// Under "disable_assertions", the loop is unbounded and now isn't called.
+//
+//go:noinline
+func UntilDeadline[T _Number](timeout T, now func() T) iter.Seq[int] {
+	Always(timeout > 0, "Loop deadline is a positive duration")
+	return func(yield func(int) bool) {
+		deadline := now() + timeout
+		for iteration := 0; true; iteration++ {
+			if now() >= deadline {
+				assertionFailureCallback(fmt.Sprintf("Runaway loop! The deadline passed after %d iterations", iteration))
+				return
+			}
+			if !yield(iteration) {
+				return
+			}
+		}
+	}
+}
+
+// UntilProgress bounds a loop by its progress instead of a number of iterations, which catches
+// livelocks such as two workers that keep undoing each other's work. remaining measures the work
+// that is left and must reach a new minimum at least once every stall iterations.
+//
+//	for range invariant.UntilProgress(func() int { return len(queue) }, 100) {
+//		if len(queue) == 0 {
+//			break
+//		}
+//		queue = process(queue)
+//	}
+//
+// Under "disable_assertions", the loop is unbounded and remaining isn't called.
+//
+//go:noinline
+func UntilProgress[T cmp.Ordered](remaining func() T, stall int) iter.Seq[int] {
+	Always(stall > 0, "Loop stall bound is a positive integer")
+	return func(yield func(int) bool) {
+		least := remaining()
+		stalled := 0
+		for iteration := 0; true; iteration++ {
+			if !yield(iteration) {
+				return
+			}
+			if current := remaining(); current < least {
+				least, stalled = current, 0
+			} else if stalled++; stalled == stall {
+				assertionFailureCallback(fmt.Sprintf("Livelocked loop! The remaining work stopped decreasing at %v for %d iterations", least, stall))
+				return
+			}
+		}
+	}
+}
+
 //go:noinline
 func Unimplemented(msg string) {
-	assertionFailureCallback(fmt.Sprintf("%s: %s\n", AssertionFailureMsgPrefix, msg))
+	assertionFailureCallback(msg)
 }
 
 //go:noinline
 func Unreachable(msg string) {
-	assertionFailureCallback(fmt.Sprintf("%s: %s\n", AssertionFailureMsgPrefix, msg))
+	assertionFailureCallback(msg)
 }
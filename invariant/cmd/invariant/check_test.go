@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+	"github.com/james-orcales/golang_snacks/snap"
+)
+
+func TestCheckEnforcesStoredOptions(t *testing.T) {
+	future := time.Now().AddDate(1, 0, 0).UTC().Truncate(24 * time.Hour)
+	past := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	writeReport(t, dir, "foo.json",
+		invariant.ReportEntry{Location: "foo/foo.go:1", Kind: "Sometimes", Message: "under threshold", Frequency: 2, MinFrequency: 5},
+		invariant.ReportEntry{Location: "foo/foo.go:2", Kind: "Sometimes", Message: "known", KnownMiss: &invariant.KnownMiss{Message: "known", Expires: future}},
+		invariant.ReportEntry{Location: "foo/foo.go:3", Kind: "Sometimes", Message: "expired", KnownMiss: &invariant.KnownMiss{Message: "expired", Expires: past}},
+	)
+	// The other package doesn't know about the known miss but still misses the assertion.
+	writeReport(t, dir, "bar.json",
+		invariant.ReportEntry{Location: "foo/foo.go:2", Kind: "Sometimes", Message: "known"},
+	)
+
+	out := captureStdout(t)
+	if err := runCheck([]string{dir}); err != errCheckFailed {
+		t.Fatalf("expected the check to fail. got %v", err)
+	}
+	if !snap.Init(`🚨 1 assertions were never true. 🚨
+	Sometimes | expired | foo/foo.go:3
+🚨 1 assertions violated their thresholds. 🚨
+	Sometimes | under threshold | foo/foo.go:1 | true 2 times, expected at least 5
+🚨 The known miss of "expired" expired on 2020-01-02. 🚨
+1 known missed assertions were allowed.
+`).IsEqual(out.String()) {
+		t.Fatal("Snapshot mismatch")
+	}
+}
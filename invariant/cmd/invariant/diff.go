@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+	"github.com/james-orcales/golang_snacks/myers"
+)
+
+func runDiff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flags.SetOutput(Stderr)
+	threshold := flags.Float64("threshold", 0.5, "report assertions whose frequency dropped by more than this fraction")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return fmt.Errorf("expected an old and a new report. got %d arguments", flags.NArg())
+	}
+	oldReport, err := readAndMerge(flags.Args()[:1])
+	if err != nil {
+		return err
+	}
+	newReport, err := readAndMerge(flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+	diff, err := invariant.DiffReports(oldReport, newReport, *threshold)
+	if err != nil {
+		return err
+	}
+
+	// Both sides list the same assertions in the same order so that the line diff pairs the old
+	// and new entry of each assertion. The header keeps either side from being empty.
+	var olds, news []invariant.ReportEntry
+	for _, pairs := range [...][][2]invariant.ReportEntry{diff.NewlyMissed, diff.Dropped} {
+		for _, pair := range pairs {
+			olds = append(olds, pair[0])
+			news = append(news, pair[1])
+		}
+	}
+	olds = append(olds, diff.Removed...)
+	news = append(news, diff.Added...)
+	if len(olds) == 0 && len(news) == 0 {
+		fmt.Fprintf(Stdout, "All %d assertions kept their coverage.\n", len(newReport.Assertions))
+		return nil
+	}
+	longestKindWord, longestMessageLength := len("kind"), len("message")
+	for _, entry := range append(olds[:len(olds):len(olds)], news...) {
+		longestKindWord = max(longestKindWord, len(entry.Kind))
+		longestMessageLength = max(longestMessageLength, len(entry.Message))
+	}
+	format := func(entries []invariant.ReportEntry) string {
+		lines := []string{fmt.Sprintf("%-10s | %*s | %-*s | %s", "count", longestKindWord, "kind", longestMessageLength, "message", "location")}
+		for _, entry := range entries {
+			lines = append(lines, fmt.Sprintf(
+				"count=%-4d | %*s | %-*s | %s",
+				entry.Frequency,
+				longestKindWord, entry.Kind,
+				longestMessageLength, entry.Message,
+				entry.Location,
+			))
+		}
+		return strings.Join(lines, "\n")
+	}
+	fmt.Fprintln(Stdout, myers.New(format(olds), format(news)).LineDiff())
+
+	if len(diff.NewlyMissed) > 0 {
+		fmt.Fprintf(Stdout, "🚨 %d assertions are no longer true. 🚨\n", len(diff.NewlyMissed))
+	}
+	if len(diff.Dropped) > 0 {
+		fmt.Fprintf(Stdout, "🚨 %d assertions dropped by more than %g%% of their frequency. 🚨\n", len(diff.Dropped), *threshold*100)
+	}
+	fmt.Fprintf(Stdout, "%d assertions were added and %d were removed.\n", len(diff.Added), len(diff.Removed))
+	if diff.IsRegression() {
+		return errCheckFailed
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+)
+
+func runFlaky(args []string) error {
+	flags := flag.NewFlagSet("flaky", flag.ContinueOnError)
+	flags.SetOutput(Stderr)
+	runs := flags.Int("runs", 0, "run `go test` this many times with the remaining arguments instead of reading reports")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	var (
+		reports []invariant.Report
+		err     error
+	)
+	if *runs > 0 {
+		reports, err = runSuite(*runs, flags.Args())
+	} else {
+		if flags.NArg() == 0 {
+			return fmt.Errorf("expected reports or -runs")
+		}
+		reports, err = readRuns(flags.Args())
+	}
+	if err != nil {
+		return err
+	}
+	flakiness, err := invariant.ClassifyFlakiness(reports...)
+	if err != nil {
+		return err
+	}
+	invariant.FprintFlakiness(Stdout, flakiness)
+	if len(flakiness.NeverCovered) > 0 {
+		return errCheckFailed
+	}
+	return nil
+}
+
+// readRuns reads one run from each path. A directory holds the per-package reports of one run, as
+// written under INVARIANT_REPORT_DIR, so they are merged like in runSuite.
+func readRuns(paths []string) ([]invariant.Report, error) {
+	reports := make([]invariant.Report, 0, len(paths))
+	for _, path := range paths {
+		perPackage, err := invariant.ReadReports(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(perPackage) == 0 {
+			return nil, fmt.Errorf("%s has no reports", path)
+		}
+		merged, err := invariant.MergeReports(perPackage...)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, merged)
+	}
+	return reports, nil
+}
+
+// runSuite runs `go test -count=1 args...` in the current directory and merges the reports of each
+// run. Runs with failed tests still count since their reports are written before exiting.
+func runSuite(runs int, args []string) ([]invariant.Report, error) {
+	if len(args) == 0 {
+		args = []string{"./..."}
+	}
+	root, err := os.MkdirTemp("", "invariant-flaky-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(root)
+	reports := make([]invariant.Report, 0, runs)
+	for run := range runs {
+		dir := filepath.Join(root, strconv.Itoa(run))
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			return nil, err
+		}
+		output := &bytes.Buffer{}
+		cmd := exec.Command("go", append([]string{"test", "-count=1"}, args...)...)
+		cmd.Env = append(os.Environ(), invariant.ReportDirEnv+"="+dir)
+		cmd.Stdout, cmd.Stderr = output, output
+		status := "ok"
+		if err := cmd.Run(); err != nil {
+			status = err.Error()
+		}
+		fmt.Fprintf(Stderr, "run %d/%d: %s\n", run+1, runs, status)
+		perPackage, err := invariant.ReadReports(dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(perPackage) == 0 {
+			return nil, fmt.Errorf("run %d wrote no reports. Do the tests call invariant.RunTestMain?\n%s", run+1, output)
+		}
+		merged, err := invariant.MergeReports(perPackage...)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, merged)
+	}
+	return reports, nil
+}
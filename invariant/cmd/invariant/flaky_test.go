@@ -0,0 +1,32 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+	"github.com/james-orcales/golang_snacks/snap"
+)
+
+func TestFlakyMergesDirectoriesIntoRuns(t *testing.T) {
+	entry := func(location string, frequency int) invariant.ReportEntry {
+		return invariant.ReportEntry{Location: location, Kind: "Sometimes", Message: location, Frequency: frequency}
+	}
+	root := t.TempDir()
+	first, second := filepath.Join(root, "1"), filepath.Join(root, "2")
+	writeReport(t, first, "foo.json", entry("foo/foo.go:1", 1))
+	writeReport(t, first, "bar.json", entry("bar/bar.go:1", 1))
+	writeReport(t, second, "foo.json", entry("foo/foo.go:1", 2))
+	writeReport(t, second, "bar.json", entry("bar/bar.go:1", 0))
+
+	out := captureStdout(t)
+	if err := runFlaky([]string{first, second}); err != nil {
+		t.Fatal(err)
+	}
+	if !snap.Init(`1 of 2 assertions were covered by all 2 runs.
+⚠️ 1 assertions were only covered by some runs. ⚠️
+	p=0.50 1/2 | Sometimes | bar/bar.go:1 | bar/bar.go:1
+`).IsEqual(out.String()) {
+		t.Fatal("Snapshot mismatch")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+)
+
+const generatedHeader = "// Code generated by invariant generate. DO NOT EDIT."
+
+// runGenerate writes the assertion table of the package in the working directory, which is where
+// `go generate` runs its directives:
+//
+//	//go:generate go run github.com/james-orcales/golang_snacks/invariant/cmd/invariant generate
+func runGenerate(args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	flags.SetOutput(Stderr)
+	output := flags.String("o", "invariant_table.go", "file to write the assertion table to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	dir := "."
+	if flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+	path := *output
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	table, err := generateTable(dir, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, table, 0o644)
+}
+
+// generateTable scans the non-test files of the package in dir that match the build constraints of
+// GOOS and GOARCH, excluding the previously generated table.
+func generateTable(dir, output string) ([]byte, error) {
+	root, _, err := invariant.FindModule(dir)
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := filepath.Rel(root, abs)
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	fset := token.NewFileSet()
+	packageName := ""
+	var sites []invariant.AssertionSite
+	context := build.Default
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") || filepath.Base(path) == output {
+			continue
+		}
+		// Files excluded from the build, such as the disable_assertions stubs, would assign IDs to
+		// assertions that never run.
+		if ok, err := context.MatchFile(dir, filepath.Base(path)); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		packageName = file.Name.Name
+		scanned, err := invariant.ScanAssertions(fset, file, filepath.ToSlash(pkg))
+		if err != nil {
+			return nil, err
+		}
+		for i := range scanned {
+			scanned[i].File = filepath.Base(scanned[i].File)
+		}
+		sites = append(sites, scanned...)
+	}
+	if packageName == "" {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, generatedHeader)
+	fmt.Fprintln(buf)
+	fmt.Fprintf(buf, "package %s\n\n", packageName)
+	if packageName != "invariant" {
+		fmt.Fprintln(buf, `import "github.com/james-orcales/golang_snacks/invariant"`)
+		fmt.Fprintln(buf)
+	}
+	qualifier := "invariant."
+	if packageName == "invariant" {
+		qualifier = ""
+	}
+	fmt.Fprintln(buf, "func init() {")
+	fmt.Fprintf(buf, "%sRegisterAssertionTable([]%sAssertionSite{\n", qualifier, qualifier)
+	for _, site := range sites {
+		fmt.Fprintf(buf, "{ID: %#016x, File: %q, Line: %d, Kind: %q, Message: %q},\n", site.ID, site.File, site.Line, site.Kind, site.Message)
+	}
+	fmt.Fprintln(buf, "})")
+	fmt.Fprintln(buf, "}")
+	return format.Source(buf.Bytes())
+}
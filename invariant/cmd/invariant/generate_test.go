@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/james-orcales/golang_snacks/snap"
+)
+
+// writeFiles writes the files, keyed by slash-separated paths, under root.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestGenerate(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.mod": "module " + testModule + "\n",
+		"foo/foo.go": `package foo
+
+import "github.com/james-orcales/golang_snacks/invariant"
+
+func Foo(x int) {
+	invariant.Always(x >= 0, "non-negative")
+}
+`,
+		"foo/foo_disabled.go": `//go:build never
+
+package foo
+
+import "github.com/james-orcales/golang_snacks/invariant"
+
+func Bar() { invariant.Unreachable("excluded by its build constraint") }
+`,
+		"foo/foo_test.go": `package foo
+
+import "github.com/james-orcales/golang_snacks/invariant"
+
+func baz() { invariant.Unreachable("excluded as a test") }
+`,
+		"foo/invariant_table.go": `package foo
+
+import "github.com/james-orcales/golang_snacks/invariant"
+
+func qux() { invariant.Unreachable("excluded as the previous table") }
+`,
+		"invariant/invariant.go": "package invariant\n",
+	})
+
+	dir := filepath.Join(root, "foo")
+	if err := runGenerate([]string{dir}); err != nil {
+		t.Fatal(err)
+	}
+	// A relative -o is relative to the package rather than the working directory.
+	if err := runGenerate([]string{"-o", "table.go", dir}); err != nil {
+		t.Fatal(err)
+	}
+	absolute := filepath.Join(t.TempDir(), "table.go")
+	if err := runGenerate([]string{"-o", absolute, dir}); err != nil {
+		t.Fatal(err)
+	}
+	table := readFile(t, filepath.Join(dir, "invariant_table.go"))
+	if !snap.Init(`// Code generated by invariant generate. DO NOT EDIT.
+
+package foo
+
+import "github.com/james-orcales/golang_snacks/invariant"
+
+func init() {
+	invariant.RegisterAssertionTable([]invariant.AssertionSite{
+		{ID: 0xab5255e0d177df43, File: "foo.go", Line: 6, Kind: "Always", Message: "non-negative"},
+	})
+}
+`).IsEqual(table) {
+		t.Fatal("Snapshot mismatch")
+	}
+	if relative := readFile(t, filepath.Join(dir, "table.go")); relative != table {
+		t.Fatalf("expected -o table.go to generate the same table. got\n%s", relative)
+	}
+	if readFile(t, absolute) != table {
+		t.Fatal("expected an absolute -o to generate the same table")
+	}
+
+	// The invariant package refers to its own declarations unqualified.
+	if err := runGenerate([]string{filepath.Join(root, "invariant")}); err != nil {
+		t.Fatal(err)
+	}
+	if !snap.Init(`// Code generated by invariant generate. DO NOT EDIT.
+
+package invariant
+
+func init() {
+	RegisterAssertionTable([]AssertionSite{})
+}
+`).IsEqual(readFile(t, filepath.Join(root, "invariant", "invariant_table.go"))) {
+		t.Fatal("Snapshot mismatch")
+	}
+}
@@ -0,0 +1,198 @@
+// Command invariant works with the reports written by invariant.RunTestMain when
+// INVARIANT_REPORT_DIR is set and generates assertion tables.
+//
+// Usage:
+//
+//	invariant merge [-o merged.json] <report.json|dir>...
+//	invariant check <report.json|dir>...
+//	invariant view [-html out.html] [-missed] <report.json|dir>...
+//	invariant tests <report.json|dir>...
+//	invariant diff [-threshold 0.5] <old.json|dir> <new.json|dir>
+//	invariant flaky <report.json|dir>... | -runs N [go test arguments]
+//	invariant generate [-o invariant_table.go] [dir]
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+)
+
+var (
+	Stdout io.Writer = os.Stdout
+	Stderr io.Writer = os.Stderr
+)
+
+type command struct {
+	Label       string
+	Usage       string
+	Description string
+	Run         func(args []string) error
+}
+
+var commands = []command{
+	{
+		Label:       "merge",
+		Usage:       "[-o merged.json] <report.json|dir>...",
+		Description: "combine reports so that an assertion exercised in any of them counts as covered",
+		Run:         runMerge,
+	},
+	{
+		Label:       "check",
+		Usage:       "<report.json|dir>...",
+		Description: "merge reports and exit with status 1 if any assertion was never true or violated its threshold",
+		Run:         runCheck,
+	},
+	{
+		Label:       "view",
+		Usage:       "[-html out.html] [-root dir] [-missed] [-color auto|always|never] <report.json|dir>...",
+		Description: "show source annotated with assertion frequencies, highlighting assertions that were never true",
+		Run:         runView,
+	},
+	{
+		Label:       "tests",
+		Usage:       "<report.json|dir>...",
+		Description: "list the tests that exercised each assertion and the assertions that only one test exercised",
+		Run:         runTests,
+	},
+	{
+		Label:       "diff",
+		Usage:       "[-threshold 0.5] <old.json|dir> <new.json|dir>",
+		Description: "compare the coverage of two runs and exit with status 1 if assertions are no longer true or their frequency dropped",
+		Run:         runDiff,
+	},
+	{
+		Label:       "flaky",
+		Usage:       "<report.json|dir>... | -runs N [go test arguments]",
+		Description: "classify assertions as always, sometimes or never covered across separate runs and exit with status 1 if any was never covered",
+		Run:         runFlaky,
+	},
+	{
+		Label:       "generate",
+		Usage:       "[-o invariant_table.go] [dir]",
+		Description: "write the static assertion IDs of the package in dir, usually through go generate",
+		Run:         runGenerate,
+	},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		printHelp()
+		return 2
+	}
+	for _, cmd := range commands {
+		if cmd.Label != args[0] {
+			continue
+		}
+		if err := cmd.Run(args[1:]); err != nil {
+			if err != errCheckFailed {
+				fmt.Fprintf(Stderr, "invariant %s: %s\n", cmd.Label, err)
+			}
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(Stderr, "%q is an unknown command\n", args[0])
+	printHelp()
+	return 2
+}
+
+func printHelp() {
+	fmt.Fprintln(Stderr, "invariant inspects assertion frequency reports and generates assertion tables")
+	fmt.Fprintln(Stderr)
+	fmt.Fprintln(Stderr, "Usage:")
+	for _, cmd := range commands {
+		fmt.Fprintf(Stderr, "    invariant %s %s\n", cmd.Label, cmd.Usage)
+		fmt.Fprintf(Stderr, "        %s\n", cmd.Description)
+	}
+}
+
+// errCheckFailed fails the command without printing an error since the command has printed the
+// offending assertions.
+var errCheckFailed = errors.New("assertions were never true")
+
+func runMerge(args []string) error {
+	flags := flag.NewFlagSet("merge", flag.ContinueOnError)
+	flags.SetOutput(Stderr)
+	output := flags.String("o", "", "write the merged report to this file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	merged, err := readAndMerge(flags.Args())
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		return invariant.WriteReport(Stdout, merged)
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := invariant.WriteReport(file, merged); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func runCheck(args []string) error {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.SetOutput(Stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	merged, err := readAndMerge(flags.Args())
+	if err != nil {
+		return err
+	}
+	// The reports carry the thresholds and known misses of the RunTests that wrote them.
+	result := invariant.AnalyzeReport(merged, invariant.TestOptions{LeastExercised: -1}, time.Now())
+	if err := invariant.WriteTestResult(Stdout, result, invariant.FormatText); err != nil {
+		return err
+	}
+	if result.Failed() {
+		return errCheckFailed
+	}
+	if len(result.Allowed) == 0 {
+		fmt.Fprintf(Stdout, "All %d assertions were true at least once and met their thresholds.\n", len(merged.Assertions))
+	}
+	return nil
+}
+
+func runTests(args []string) error {
+	flags := flag.NewFlagSet("tests", flag.ContinueOnError)
+	flags.SetOutput(Stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	merged, err := readAndMerge(flags.Args())
+	if err != nil {
+		return err
+	}
+	invariant.FprintAttribution(Stdout, merged)
+	return nil
+}
+
+func readAndMerge(paths []string) (invariant.Report, error) {
+	if len(paths) == 0 {
+		return invariant.Report{}, fmt.Errorf("expected at least one report")
+	}
+	reports, err := invariant.ReadReports(paths...)
+	if err != nil {
+		return invariant.Report{}, err
+	}
+	if len(reports) == 0 {
+		return invariant.Report{}, fmt.Errorf("no reports found in %v", paths)
+	}
+	return invariant.MergeReports(reports...)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/james-orcales/golang_snacks/internal/term"
+	"github.com/james-orcales/golang_snacks/invariant"
+)
+
+// annotatedFile is a source file with the assertions of a report attached to their lines.
+type annotatedFile struct {
+	Path   string
+	Lines  []annotatedLine
+	Missed int
+	Total  int
+}
+
+type annotatedLine struct {
+	Number int
+	Text   string
+	// Assertion is nil if the line has no assertion.
+	Assertion *invariant.ReportEntry
+}
+
+func runView(args []string) error {
+	flags := flag.NewFlagSet("view", flag.ContinueOnError)
+	flags.SetOutput(Stderr)
+	htmlPath := flags.String("html", "", "write an HTML page to this file instead of printing to the terminal")
+	root := flags.String("root", "", "module root that report locations are relative to (default: module of the working directory)")
+	onlyMissed := flags.Bool("missed", false, "only show files with assertions that were never true")
+	color := flags.String("color", "auto", "colorize terminal output: auto, always, never")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	merged, err := readAndMerge(flags.Args())
+	if err != nil {
+		return err
+	}
+	if *root == "" {
+		*root, _, err = invariant.FindModule(".")
+		if err != nil {
+			return err
+		}
+	}
+	files, err := annotate(*root, merged)
+	if err != nil {
+		return err
+	}
+	if *onlyMissed {
+		kept := files[:0]
+		for _, file := range files {
+			if file.Missed > 0 {
+				kept = append(kept, file)
+			}
+		}
+		files = kept
+	}
+
+	if *htmlPath != "" {
+		out, err := os.Create(*htmlPath)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		if err := viewTemplate.Execute(out, files); err != nil {
+			return err
+		}
+		return out.Close()
+	}
+
+	isColored := false
+	switch *color {
+	case "always":
+		isColored = true
+	case "never":
+	case "auto":
+		isColored = term.IsColored(Stdout)
+	default:
+		return fmt.Errorf("-color must be auto, always or never. got %q", *color)
+	}
+	fprintAnnotated(Stdout, files, isColored)
+	return nil
+}
+
+// annotate reads every file referenced by the report. Files are sorted by path.
+func annotate(root string, report invariant.Report) ([]annotatedFile, error) {
+	byFile := make(map[string]map[int]*invariant.ReportEntry)
+	for i := range report.Assertions {
+		entry := &report.Assertions[i]
+		path, line := entry.FileLine()
+		if line == 0 {
+			return nil, fmt.Errorf("malformed location %q", entry.Location)
+		}
+		if byFile[path] == nil {
+			byFile[path] = make(map[int]*invariant.ReportEntry)
+		}
+		byFile[path][line] = entry
+	}
+
+	paths := make([]string, 0, len(byFile))
+	for path := range byFile {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	files := make([]annotatedFile, 0, len(paths))
+	for _, path := range paths {
+		content, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(path)))
+		if err != nil {
+			return nil, err
+		}
+		file := annotatedFile{Path: path}
+		for i, text := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+			line := annotatedLine{Number: i + 1, Text: text, Assertion: byFile[path][i+1]}
+			if line.Assertion != nil {
+				file.Total++
+				if line.Assertion.IsMissed() {
+					file.Missed++
+				}
+			}
+			file.Lines = append(file.Lines, line)
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// fprintAnnotated prints each file with the assertion frequency in the gutter. Missed assertions
+// are marked with a `!` so they stand out without colors.
+func fprintAnnotated(w io.Writer, files []annotatedFile, isColored bool) {
+	paint := func(color, text string) string {
+		if !isColored {
+			return text
+		}
+		return color + text + term.Reset
+	}
+	for _, file := range files {
+		fmt.Fprintf(w, "=== %s (%d/%d assertions covered) ===\n", file.Path, file.Total-file.Missed, file.Total)
+		width := len(strconv.Itoa(len(file.Lines)))
+		for _, line := range file.Lines {
+			number := fmt.Sprintf("%*d", width, line.Number)
+			switch {
+			case line.Assertion == nil:
+				fmt.Fprintf(w, "%8s %s  %s\n", "", paint(term.Dim, number), line.Text)
+			case line.Assertion.IsMissed():
+				fmt.Fprintf(w, "%s %s  %s\n", paint(term.Red, fmt.Sprintf("%8s", "!0")), paint(term.Dim, number), paint(term.Red, line.Text))
+			default:
+				fmt.Fprintf(w, "%s %s  %s\n", paint(term.Green, fmt.Sprintf("%8d", line.Assertion.Frequency)), paint(term.Dim, number), line.Text)
+			}
+		}
+	}
+}
+
+var viewTemplate = template.Must(template.New("view").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>invariant coverage</title>
+<style>
+	body { background: #111; color: #bbb; font-family: Menlo, monospace; font-size: 13px; margin: 0; }
+	#nav { position: sticky; top: 0; background: #222; padding: 8px; border-bottom: 1px solid #333; }
+	.file { display: none; }
+	.file:target, .file.first { display: block; }
+	table { border-collapse: collapse; }
+	td { padding: 0 8px; white-space: pre; vertical-align: top; }
+	.gutter { text-align: right; color: #666; user-select: none; }
+	.hit { background: #132713; }
+	.hit .count { color: #5c5; }
+	.miss { background: #3a1212; }
+	.miss .count { color: #f55; font-weight: bold; }
+	.legend span { margin-right: 16px; }
+</style>
+</head>
+<body>
+<div id="nav">
+	<select onchange="location.hash = this.value">
+	{{- range $i, $file := . }}
+		<option value="file{{ $i }}">{{ $file.Path }} ({{ $file.Missed }} missed / {{ $file.Total }})</option>
+	{{- end }}
+	</select>
+	<span class="legend"><span class="count" style="color:#5c5">frequency</span><span class="count" style="color:#f55">never true</span></span>
+</div>
+{{- range $i, $file := . }}
+<div class="file{{ if eq $i 0 }} first{{ end }}" id="file{{ $i }}">
+<table>
+{{- range $file.Lines }}
+{{- if not .Assertion }}
+<tr><td class="gutter"></td><td class="gutter">{{ .Number }}</td><td>{{ .Text }}</td></tr>
+{{- else if .Assertion.IsMissed }}
+<tr class="miss" title="{{ .Assertion.Kind }}: {{ .Assertion.Message }}"><td class="gutter count">0</td><td class="gutter">{{ .Number }}</td><td>{{ .Text }}</td></tr>
+{{- else }}
+<tr class="hit" title="{{ .Assertion.Kind }}: {{ .Assertion.Message }}"><td class="gutter count">{{ .Assertion.Frequency }}</td><td class="gutter">{{ .Number }}</td><td>{{ .Text }}</td></tr>
+{{- end }}
+{{- end }}
+</table>
+</div>
+{{- end }}
+<script>
+	// Only the first file is shown until another one is selected.
+	window.addEventListener("hashchange", function () {
+		document.querySelector(".first").classList.remove("first");
+	}, { once: true });
+</script>
+</body>
+</html>
+`))
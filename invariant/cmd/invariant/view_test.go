@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+	"github.com/james-orcales/golang_snacks/snap"
+)
+
+const testModule = "example.com/foo"
+
+// writeReport writes the entries as the report name in dir.
+func writeReport(t *testing.T, dir, name string, entries ...invariant.ReportEntry) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	report := invariant.Report{Version: invariant.ReportVersion, Module: testModule, Assertions: entries}
+	if err := invariant.WriteReport(out, report); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// captureStdout redirects Stdout to the returned buffer until the test ends.
+func captureStdout(t *testing.T) *bytes.Buffer {
+	out := &bytes.Buffer{}
+	Stdout = out
+	t.Cleanup(func() { Stdout = os.Stdout })
+	return out
+}
+
+func TestView(t *testing.T) {
+	root := t.TempDir()
+	const src = `package foo
+
+func Foo(x int) {
+	invariant.Sometimes(x > 0, "positive")
+	invariant.Sometimes(x > 100, "large")
+	invariant.ProbablyRatio(x < 0, 0, 0.5, "negative")
+}
+`
+	if err := os.MkdirAll(filepath.Join(root, "foo"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "foo", "foo.go"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reports := t.TempDir()
+	writeReport(t, reports, "foo.json",
+		invariant.ReportEntry{Location: "foo/foo.go:4", Kind: "Sometimes", Message: "positive", Frequency: 12},
+		invariant.ReportEntry{Location: "foo/foo.go:5", Kind: "Sometimes", Message: "large"},
+		// Evaluated but never true, which is within its band.
+		invariant.ReportEntry{Location: "foo/foo.go:6", Kind: "ProbablyRatio", Message: "negative", Evaluations: 12, RatioHi: 0.5},
+	)
+
+	out := captureStdout(t)
+	if err := runView([]string{"-root", root, "-color", "never", reports}); err != nil {
+		t.Fatal(err)
+	}
+	if !snap.Init(`=== foo/foo.go (2/3 assertions covered) ===
+         1  package foo
+         2  
+         3  func Foo(x int) {
+      12 4  	invariant.Sometimes(x > 0, "positive")
+      !0 5  	invariant.Sometimes(x > 100, "large")
+       0 6  	invariant.ProbablyRatio(x < 0, 0, 0.5, "negative")
+         7  }
+`).IsEqual(out.String()) {
+		t.Fatal("Snapshot mismatch")
+	}
+
+	html := filepath.Join(t.TempDir(), "view.html")
+	if err := runView([]string{"-root", root, "-html", html, reports}); err != nil {
+		t.Fatal(err)
+	}
+	page, err := os.ReadFile(html)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits, misses := strings.Count(string(page), `<tr class="hit"`), strings.Count(string(page), `<tr class="miss"`); hits != 2 || misses != 1 {
+		t.Fatalf("expected 2 hit and 1 missed rows. got %d and %d", hits, misses)
+	}
+}
@@ -0,0 +1,13 @@
+// Command invariantvet checks invariant assertion call sites. See package vet.
+//
+// Usage:
+//
+//	go vet -vettool=$(which invariantvet) ./...
+//	invariantvet [-invariant.loop=false] [dir|dir/...]...
+package main
+
+import "github.com/james-orcales/golang_snacks/invariant/vet"
+
+func main() {
+	vet.Main(vet.Invariant)
+}
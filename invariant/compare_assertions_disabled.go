@@ -0,0 +1,25 @@
+//go:build disable_assertions
+
+package invariant
+
+import "cmp"
+
+var AssertionDiffHook func(expected, actual string) string
+
+func AlwaysEqual[T comparable](actual, expected T, msg string) {
+}
+
+func AlwaysLess[T cmp.Ordered](a, b T, msg string) {
+}
+
+func AlwaysInRange[T cmp.Ordered](x, lo, hi T, msg string) {
+}
+
+func AlwaysLen(x any, n int, msg string) {
+}
+
+func AlwaysContains[S ~[]E, E comparable](s S, x E, msg string) {
+}
+
+func AlwaysDeepEqual(actual, expected any, msg string) {
+}
@@ -0,0 +1,139 @@
+//go:build !disable_assertions
+
+package invariant
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+const (
+	// Strings and slices that are longer than this are diffed instead of printed side by side.
+	maxInlineComparisonLength = 64
+)
+
+// AssertionDiffHook formats the difference between the expected and actual values of a failed
+// comparison assertion. Multi-line values are compared line by line. This package doesn't depend
+// on a diff implementation, so set it to myers.AssertionDiff to opt in. If nil, both values are
+// printed instead.
+var AssertionDiffHook func(expected, actual string) string
+
+// AlwaysEqual calls assertionFailureCallback if actual != expected and prints both values.
+// Prefer this over Always(actual == expected) so that the operands are logged.
+//
+//go:noinline
+func AlwaysEqual[T comparable](actual, expected T, msg string) {
+	if actual == expected {
+		registerAssertion()
+	} else {
+		assertionFailureCallback(formatComparison(actual, expected, msg))
+	}
+}
+
+// AlwaysLess calls assertionFailureCallback if a >= b.
+//
+//go:noinline
+func AlwaysLess[T cmp.Ordered](a, b T, msg string) {
+	if a < b {
+		registerAssertion()
+	} else {
+		assertionFailureCallback(fmt.Sprintf("expected %#v < %#v. %s", a, b, msg))
+	}
+}
+
+// AlwaysInRange calls assertionFailureCallback if x is outside of the inclusive range [lo, hi].
+//
+//go:noinline
+func AlwaysInRange[T cmp.Ordered](x, lo, hi T, msg string) {
+	if lo <= x && x <= hi {
+		registerAssertion()
+	} else {
+		assertionFailureCallback(fmt.Sprintf("expected %#v in [%#v, %#v]. %s", x, lo, hi, msg))
+	}
+}
+
+// AlwaysLen calls assertionFailureCallback if len(x) != n. x is anything that len accepts: a
+// string, slice, array, map or channel.
+//
+//go:noinline
+func AlwaysLen(x any, n int, msg string) {
+	v := reflect.ValueOf(x)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+	default:
+		assertionFailureCallback(fmt.Sprintf("invariant.AlwaysLen requires a value with a length. got %T. %s", x, msg))
+		return
+	}
+	if v.Len() == n {
+		registerAssertion()
+	} else {
+		assertionFailureCallback(fmt.Sprintf("expected length %d. got %d: %s. %s", n, v.Len(), formatValue(x), msg))
+	}
+}
+
+// AlwaysContains calls assertionFailureCallback if x is not an element of s.
+//
+//go:noinline
+func AlwaysContains[S ~[]E, E comparable](s S, x E, msg string) {
+	if slices.Contains(s, x) {
+		registerAssertion()
+	} else {
+		assertionFailureCallback(fmt.Sprintf("expected %#v in %s. %s", x, formatValue(s), msg))
+	}
+}
+
+// AlwaysDeepEqual calls assertionFailureCallback if actual and expected are not
+// reflect.DeepEqual and prints both values.
+//
+//go:noinline
+func AlwaysDeepEqual(actual, expected any, msg string) {
+	if reflect.DeepEqual(actual, expected) {
+		registerAssertion()
+	} else {
+		assertionFailureCallback(formatComparison(actual, expected, msg))
+	}
+}
+
+// formatComparison prints short values inline. Long strings and slices are diffed through
+// AssertionDiffHook, with slices formatted as one element per line.
+func formatComparison(actual, expected any, msg string) string {
+	a, e := fmt.Sprintf("%#v", actual), fmt.Sprintf("%#v", expected)
+	isLong := len(a) > maxInlineComparisonLength || len(e) > maxInlineComparisonLength
+	for _, x := range [...]any{actual, expected} {
+		if s, ok := x.(string); ok && strings.Contains(s, "\n") {
+			isLong = true
+		}
+	}
+	if !isLong || AssertionDiffHook == nil {
+		return fmt.Sprintf("expected %s. got %s. %s", e, a, msg)
+	}
+	return fmt.Sprintf("%s. expected (-) vs got (+):\n%s", msg, AssertionDiffHook(formatLines(expected), formatLines(actual)))
+}
+
+// formatLines formats strings as is and slices with one element per line. Other values use %#v.
+func formatLines(x any) string {
+	if s, ok := x.(string); ok {
+		return s
+	}
+	v := reflect.ValueOf(x)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return fmt.Sprintf("%#v", x)
+	}
+	lines := make([]string, v.Len())
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%#v", v.Index(i).Interface())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatValue truncates long values so that failure messages stay readable.
+func formatValue(x any) string {
+	s := fmt.Sprintf("%#v", x)
+	if len(s) > maxInlineComparisonLength*4 {
+		return s[:maxInlineComparisonLength*4] + "..."
+	}
+	return s
+}
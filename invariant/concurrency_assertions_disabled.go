@@ -0,0 +1,38 @@
+//go:build disable_assertions
+
+package invariant
+
+func AlwaysLocked(mu interface {
+	TryLock() bool
+	Unlock()
+}, msg string) {
+}
+
+func AlwaysOwnedBy(owner Goroutine, msg string) {
+}
+
+type Reentrancy struct{}
+
+func NotReentrant(guard *Reentrancy, msg string) (exit func()) {
+	return leaveReentrancy
+}
+
+func leaveReentrancy() {}
+
+type HappensBefore struct{}
+
+func NewHappensBefore() *HappensBefore {
+	return &HappensBefore{}
+}
+
+func (hb *HappensBefore) Happen(event string) {
+}
+
+func (hb *HappensBefore) Release(edge string) {
+}
+
+func (hb *HappensBefore) Acquire(edge string) {
+}
+
+func AlwaysHappenedBefore(hb *HappensBefore, event string, msg string) {
+}
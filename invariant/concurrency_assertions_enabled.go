@@ -0,0 +1,190 @@
+//go:build !disable_assertions
+
+package invariant
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// AlwaysLocked calls assertionFailureCallback if mu is unlocked. It accepts a *sync.Mutex, or a
+// *sync.RWMutex, which counts as locked while held by either a reader or a writer. It can't tell
+// which goroutine holds the lock, only that one does.
+//
+//	func (c *Cache) evict() {
+//		invariant.AlwaysLocked(&c.mutex, "evict is called with the cache locked")
+//	}
+//
+//go:noinline
+func AlwaysLocked(mu interface {
+	TryLock() bool
+	Unlock()
+}, msg string) {
+	if mu.TryLock() {
+		mu.Unlock()
+		assertionFailureCallback(fmt.Sprintf("the mutex is unlocked. %s", msg))
+	} else {
+		registerAssertion()
+	}
+}
+
+// AlwaysOwnedBy calls assertionFailureCallback if it isn't called by owner. Values that must stay on
+// one goroutine, such as an event loop, record the goroutine that creates them.
+//
+//	func NewLoop() *Loop {
+//		return &Loop{owner: invariant.CurrentGoroutine()}
+//	}
+//
+//	func (l *Loop) Schedule(task func()) {
+//		invariant.AlwaysOwnedBy(l.owner, "Loop is only used by the goroutine that created it")
+//		l.tasks = append(l.tasks, task)
+//	}
+//
+//go:noinline
+func AlwaysOwnedBy(owner Goroutine, msg string) {
+	if current := CurrentGoroutine(); current == owner {
+		registerAssertion()
+	} else {
+		assertionFailureCallback(fmt.Sprintf("goroutine %d is owned by goroutine %d. %s", current, owner, msg))
+	}
+}
+
+// Reentrancy guards a function for NotReentrant. The zero value is ready to use.
+type Reentrancy struct {
+	entered atomic.Bool
+}
+
+// NotReentrant calls assertionFailureCallback if the function guarded by guard is entered again
+// before it returns, either recursively or by another goroutine. Defer the returned function to
+// leave the guard.
+//
+//	func (s *Stream) Flush() error {
+//		defer invariant.NotReentrant(&s.flushing, "Flush doesn't call back into itself")()
+//		...
+//	}
+//
+//go:noinline
+func NotReentrant(guard *Reentrancy, msg string) (exit func()) {
+	if !guard.entered.CompareAndSwap(false, true) {
+		assertionFailureCallback(fmt.Sprintf("reentered a non-reentrant function. %s", msg))
+		// The guard still belongs to the first call.
+		return func() {}
+	}
+	registerAssertion()
+	return func() {
+		guard.entered.Store(false)
+	}
+}
+
+// HappensBefore tracks the causality between named events across goroutines with vector clocks.
+// Since the tracker can't observe channels and mutexes, synchronization is declared with Release
+// before a goroutine publishes its work and Acquire after another one receives it. Events happen
+// before everything that follows them on the same goroutine, and before everything that follows
+// an Acquire of an edge that was Released after them.
+//
+//	// Worker
+//	hb.Happen("result written")
+//	hb.Release("results")
+//	results <- result
+//
+//	// Consumer
+//	result := <-results
+//	hb.Acquire("results")
+//	invariant.AlwaysHappenedBefore(hb, "result written", "Results are written before they're read")
+//
+// It is safe for concurrent use. Every goroutine that uses it is remembered for its lifetime.
+type HappensBefore struct {
+	mutex      sync.Mutex
+	goroutines map[Goroutine]vectorClock
+	edges      map[string]vectorClock
+	events     map[string]happening
+}
+
+// vectorClock is the number of events of each goroutine that happened before now.
+type vectorClock map[Goroutine]uint64
+
+// happening is the first occurrence of an event.
+type happening struct {
+	goroutine Goroutine
+	clock     uint64
+}
+
+func NewHappensBefore() *HappensBefore {
+	return &HappensBefore{
+		goroutines: make(map[Goroutine]vectorClock),
+		edges:      make(map[string]vectorClock),
+		events:     make(map[string]happening),
+	}
+}
+
+// Happen records an occurrence of event on the current goroutine.
+func (hb *HappensBefore) Happen(event string) {
+	g := CurrentGoroutine()
+	hb.mutex.Lock()
+	defer hb.mutex.Unlock()
+	clock := hb.clock(g)
+	clock[g]++
+	if _, ok := hb.events[event]; !ok {
+		hb.events[event] = happening{goroutine: g, clock: clock[g]}
+	}
+}
+
+// Release publishes everything that happened before now on the current goroutine to the
+// goroutines that later Acquire edge.
+func (hb *HappensBefore) Release(edge string) {
+	g := CurrentGoroutine()
+	hb.mutex.Lock()
+	defer hb.mutex.Unlock()
+	published := hb.edges[edge]
+	if published == nil {
+		published = make(vectorClock)
+		hb.edges[edge] = published
+	}
+	published.merge(hb.clock(g))
+}
+
+// Acquire makes everything that was Released to edge happen before now on the current goroutine.
+func (hb *HappensBefore) Acquire(edge string) {
+	g := CurrentGoroutine()
+	hb.mutex.Lock()
+	defer hb.mutex.Unlock()
+	hb.clock(g).merge(hb.edges[edge])
+}
+
+// clock must be called while holding the mutex.
+func (hb *HappensBefore) clock(g Goroutine) vectorClock {
+	clock := hb.goroutines[g]
+	if clock == nil {
+		clock = make(vectorClock)
+		hb.goroutines[g] = clock
+	}
+	return clock
+}
+
+func (clock vectorClock) merge(other vectorClock) {
+	for g, count := range other {
+		clock[g] = max(clock[g], count)
+	}
+}
+
+// AlwaysHappenedBefore calls assertionFailureCallback unless event happened before now on the
+// current goroutine, as tracked by hb.
+//
+//go:noinline
+func AlwaysHappenedBefore(hb *HappensBefore, event string, msg string) {
+	g := CurrentGoroutine()
+	hb.mutex.Lock()
+	failure := ""
+	if happened, ok := hb.events[event]; !ok {
+		failure = fmt.Sprintf("%q never happened. %s", event, msg)
+	} else if hb.clock(g)[happened.goroutine] < happened.clock {
+		failure = fmt.Sprintf("%q happened concurrently with goroutine %d. %s", event, g, msg)
+	}
+	hb.mutex.Unlock()
+	if failure == "" {
+		registerAssertion()
+	} else {
+		assertionFailureCallback(failure)
+	}
+}
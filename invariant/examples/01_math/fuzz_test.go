@@ -0,0 +1,71 @@
+//go:build !disable_assertions
+
+package math_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+)
+
+// TestFuzzWorkerReportsAreMerged poses as the parent of `go test -fuzz`, whose workers leave their
+// reports in the directory shared through INVARIANT_FUZZ_WORKER_REPORT_DIR. It isn't parallel so
+// that the other tests don't evaluate the assertions until it is done.
+func TestFuzzWorkerReportsAreMerged(t *testing.T) {
+	const location = "invariant/examples/01_math/math.go:15"
+	shared := t.TempDir()
+	t.Setenv("INVARIANT_FUZZ_WORKER_REPORT_DIR", shared)
+	invariant.IsRunningUnderGoFuzz = true
+	t.Cleanup(func() { invariant.IsRunningUnderGoFuzz = false })
+
+	for i, entry := range []invariant.ReportEntry{
+		{Location: location, Kind: "Always", Message: "Addition is commutative", Frequency: 1000, Tests: []string{"FuzzAdd"}},
+		{Location: location, Kind: "Always", Message: "Addition is commutative", Frequency: 234, Tests: []string{"FuzzSum"}},
+		// Assertions that the parent doesn't track are dropped.
+		{Location: "invariant/examples/01_math/gone.go:1", Kind: "Always", Message: "gone", Frequency: 1},
+	} {
+		out := &bytes.Buffer{}
+		report := invariant.Report{Version: invariant.ReportVersion, Assertions: []invariant.ReportEntry{entry}}
+		if err := invariant.WriteReport(out, report); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(shared, fmt.Sprintf("worker%d.json", i)), out.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A worker that was killed while writing its report is skipped.
+	if err := os.WriteFile(filepath.Join(shared, "killed.json"), []byte(`{"Version":`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := invariant.WriteAssertionReport(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := invariant.ReadReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var merged *invariant.ReportEntry
+	for i, entry := range report.Assertions {
+		if entry.Location == location {
+			merged = &report.Assertions[i]
+		}
+		if entry.Location == "invariant/examples/01_math/gone.go:1" {
+			t.Fatal("expected the untracked assertion of a worker to be dropped")
+		}
+	}
+	if merged == nil || merged.Frequency != 1234 {
+		t.Fatalf("expected the frequencies of the workers to be summed. got %+v", merged)
+	}
+	if len(merged.Tests) != 2 || merged.Tests[0] != "FuzzAdd" || merged.Tests[1] != "FuzzSum" {
+		t.Fatalf("expected the tests of the workers to be merged. got %v", merged.Tests)
+	}
+	if _, err := os.Stat(shared); !os.IsNotExist(err) {
+		t.Fatalf("expected the shared directory to be removed. got %v", err)
+	}
+}
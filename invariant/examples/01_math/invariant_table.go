@@ -0,0 +1,37 @@
+// Code generated by invariant generate. DO NOT EDIT.
+
+package math
+
+import "github.com/james-orcales/golang_snacks/invariant"
+
+func init() {
+	invariant.RegisterAssertionTable([]invariant.AssertionSite{
+		{ID: 0xf5ec8b238275e62b, File: "math.go", Line: 11, Kind: "Always", Message: "Sum is greater than the biggest addend when both addends are positive"},
+		{ID: 0x30e624651ff3e0d8, File: "math.go", Line: 15, Kind: "Always", Message: "Addition is commutative"},
+		{ID: 0x30e98a651ff6c401, File: "math.go", Line: 16, Kind: "Always", Message: "Addition is commutative"},
+		{ID: 0xe68dcf3cde3db7dc, File: "math.go", Line: 20, Kind: "Always", Message: "Adding zero to a number should leave it unchanged"},
+		{ID: 0xe691353cde409b05, File: "math.go", Line: 23, Kind: "Always", Message: "Adding zero to a number should leave it unchanged"},
+		{ID: 0xeaf8416784ba0cd6, File: "math.go", Line: 28, Kind: "Always", Message: "Adding a number and its additive inverse should yield zero"},
+		{ID: 0xeafba76784bcefff, File: "math.go", Line: 31, Kind: "Always", Message: "Adding a number and its additive inverse should yield zero"},
+		{ID: 0xd6d3f542b0573d28, File: "math.go", Line: 60, Kind: "Sometimes", Message: "Subtrahend is not equal to minuend"},
+		{ID: 0x36285f5c9bbbe569, File: "math.go", Line: 61, Kind: "Always", Message: "Subtraction is non-commutative"},
+		{ID: 0x1ba9a62f518783bd, File: "math.go", Line: 66, Kind: "Sometimes", Message: "Subtraction is non-associative"},
+		{ID: 0xa1b4d5aff8c02af2, File: "math.go", Line: 70, Kind: "Always", Message: "Subtracting zero leaves the number unchanged"},
+		{ID: 0xd22b60f759734c0f, File: "math.go", Line: 74, Kind: "Always", Message: "Subtraction equals addition of additive inverse"},
+		{ID: 0x62121ed33fd5d5f6, File: "math.go", Line: 77, Kind: "Always", Message: "Subtrahend increases if minuend is negative"},
+		{ID: 0xdf1256e820c8e75c, File: "math.go", Line: 88, Kind: "Always", Message: "Product must be zero when multiplicand is zero"},
+		{ID: 0x0d752ced60488083, File: "math.go", Line: 91, Kind: "Always", Message: "Product must be zero when multiplier is zero"},
+		{ID: 0x023b3a54e6e770f1, File: "math.go", Line: 97, Kind: "Always", Message: "If some operand is one, then the product is equal to the other operand"},
+		{ID: 0x68d5031527a478e6, File: "math.go", Line: 100, Kind: "Always", Message: "Product must equal multiplier when multiplicand is one"},
+		{ID: 0x6b8cd4f7ed688dea, File: "math.go", Line: 103, Kind: "Always", Message: "Product must equal multiplicand when multiplier is one"},
+		{ID: 0xb69e2aa147d11540, File: "math.go", Line: 108, Kind: "Always", Message: "Product must be negated when multiplicand is -1"},
+		{ID: 0x05de2c04f36e8d2f, File: "math.go", Line: 111, Kind: "Always", Message: "Product must be negated when multiplier is -1"},
+		{ID: 0xd371f52532eff5c7, File: "math.go", Line: 117, Kind: "Always", Message: "Product must be positive when both operands are positive"},
+		{ID: 0x4966e34a3ab4942b, File: "math.go", Line: 121, Kind: "Always", Message: "Product must be positive when both operands are negative"},
+		{ID: 0x1a758a541a1cc81a, File: "math.go", Line: 125, Kind: "Always", Message: "Product must be negative when operands have opposing signs"},
+		{ID: 0x1a78f0541a1fab43, File: "math.go", Line: 129, Kind: "Always", Message: "Product must be negative when operands have opposing signs"},
+		{ID: 0x343bb6bf85fe5785, File: "math.go", Line: 148, Kind: "Always", Message: "Product must equal repeated addition of the multiplicand"},
+		{ID: 0x809bca19d70e987e, File: "math.go", Line: 149, Kind: "Always", Message: "Product must equal repeated addition of the multiplier"},
+		{ID: 0xc340075fd619e49d, File: "math.go", Line: 150, Kind: "Always", Message: "Multiplication is commutative"},
+	})
+}
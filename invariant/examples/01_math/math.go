@@ -1,5 +1,7 @@
 package math
 
+//go:generate go run github.com/james-orcales/golang_snacks/invariant/cmd/invariant generate
+
 import "github.com/james-orcales/golang_snacks/invariant"
 
 func Add(x, y int) int {
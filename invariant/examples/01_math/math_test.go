@@ -4,7 +4,7 @@ import (
 	"testing"
 
 	"github.com/james-orcales/golang_snacks/invariant"
-	math "github.com/james-orcales/golang_snacks/invariant/examples/02_math"
+	math "github.com/james-orcales/golang_snacks/invariant/examples/01_math"
 )
 
 func TestMain(m *testing.M) {
@@ -5,25 +5,21 @@ import (
 	"log/slog"
 	"net"
 	"net/smtp"
-	"strings"
-	"sync/atomic"
 	"time"
 
 	"github.com/james-orcales/golang_snacks/invariant"
 )
 
 func main() {
-	invariant.AssertionFailureCallback = func(msg string) {
-		atomic.AddInt32(&assertion_failure_count, 1)
-		panic(msg)
-	}
+	// Count assertion failures and keep serving. The failures are announced via email every
+	// notify_frequency.
+	invariant.SetFailurePolicy(invariant.FailurePolicy{
+		Mode:            invariant.FailureCount,
+		SummaryWriter:   email_writer{},
+		SummaryInterval: notify_frequency,
+	})
 	// Ensure any leftover assertions are announced.
-	defer func() {
-		if atomic.LoadInt32(&assertion_failure_count) > 0 {
-			send_email()
-			atomic.SwapInt32(&assertion_failure_count, 0)
-		}
-	}()
+	defer invariant.FlushFailureSummary()
 
 	addr, err := net.ResolveTCPAddr("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -68,63 +64,37 @@ func main() {
 		}
 	}()
 
-	ticker := time.Tick(notify_frequency)
-
-	// Log assertion failures and continue; let all other panics crash the program.
 	database := make(map[string]int)
-	for {
-		should_shutdown := func() bool {
-			defer func() {
-				if err := recover(); err != nil {
-					if strErr, ok := err.(string); ok && strings.HasPrefix(strErr, invariant.AssertionFailureMsgPrefix) {
-						slog.Error(strErr)
-					}
-				}
-			}()
-			select {
-			case message := <-messages:
-				switch message {
-				default:
-					// To assign a person for each assertion, modify the signature to take a third string
-					// containing their email address. I prefer to make it the third parameter so that the most relevant
-					// information (1) cond (2) msg are still read first.
-					// invariant.Always(message != "you gave me up", "Never gonna give you up.", "firstlast@myorg.io")
-					invariant.Always(message != "you gave me up", "Never gonna give you up.")
-					database[message] += 1
-				case "shutdown":
-					// NOTE: this doesn't handle signal interrupts. You can still drop assertions with CTRL-C sending
-					// SIGINT for example.
-					return true
-				}
-			case <-ticker:
-				if atomic.LoadInt32(&assertion_failure_count) > 0 {
-					send_email()
-					atomic.SwapInt32(&assertion_failure_count, 0)
-				}
-			}
-			return false
-		}()
-		if should_shutdown {
+	for message := range messages {
+		if message == "shutdown" {
+			// NOTE: this doesn't handle signal interrupts. You can still drop assertions with CTRL-C sending
+			// SIGINT for example.
 			break
 		}
+		// To assign a person for each assertion, modify the signature to take a third string
+		// containing their email address. I prefer to make it the third parameter so that the most relevant
+		// information (1) cond (2) msg are still read first.
+		// invariant.Always(message != "you gave me up", "Never gonna give you up.", "firstlast@myorg.io")
+		invariant.Always(message != "you gave me up", "Never gonna give you up.") //invariant:loop
+		database[message] += 1
 	}
 	for key, val := range database {
 		fmt.Println(key, val)
 	}
 }
 
-var (
-	assertion_failure_count int32 = 0
-	email_sent_count              = 0
-)
+var email_sent_count = 0
 
 const notify_frequency = time.Second * 30
 
-func send_email() {
+// email_writer sends each summary of invariant.FlushFailureSummary as an email.
+type email_writer struct{}
+
+func (email_writer) Write(summary []byte) (int, error) {
 	// Safety guard so we don't mistakenly send a million emails...
 	const max_emails_sent = 2
-	if atomic.LoadInt32(&assertion_failure_count) == 0 || email_sent_count >= max_emails_sent {
-		return
+	if email_sent_count >= max_emails_sent {
+		return len(summary), nil
 	}
 	err := smtp.SendMail(
 		"smtp.gmail.com:587",
@@ -132,15 +102,17 @@ func send_email() {
 		FROM,
 		[]string{RECIPIENT},
 		[]byte(fmt.Sprintf(
-			"To: %s\r\nSubject: 🚨 ASSERTION FAILURE 🚨\r\n\r\nDetected %d assertion failures in the last %d seconds.",
+			"To: %s\r\nSubject: 🚨 ASSERTION FAILURE 🚨\r\n\r\nDetected assertion failures in the last %d seconds.\r\n\r\n%s",
 			RECIPIENT,
-			assertion_failure_count,
 			notify_frequency/time.Second,
+			summary,
 		)),
 	)
 	if err != nil {
 		slog.Error("Failed to announce assertion failure via email", "error", err)
-		return
+		return 0, err
 	}
-	slog.Info("Assertion failures were announced via email.", "assertion_failure_count", assertion_failure_count)
+	email_sent_count++
+	slog.Info("Assertion failures were announced via email.")
+	return len(summary), nil
 }
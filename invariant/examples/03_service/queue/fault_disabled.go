@@ -0,0 +1,7 @@
+//go:build !fault_injection
+
+package queue
+
+func dropJob() error {
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build fault_injection
+
+package queue
+
+import (
+	"errors"
+	"math/rand/v2"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+)
+
+// dropJob loses a job every now and then. Its assertions are only analyzed when testing with
+// -tags fault_injection.
+func dropJob() error {
+	if rand.IntN(8) == 0 {
+		invariant.Reachable("A job is dropped")
+		return errors.New("dropped")
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+// Package queue is a FIFO of job costs.
+package queue
+
+import "github.com/james-orcales/golang_snacks/invariant"
+
+type Queue struct {
+	costs []int
+}
+
+func (q *Queue) Push(cost int) {
+	invariant.Always(cost >= 0, "Costs are never negative")
+	q.costs = append(q.costs, cost)
+	invariant.Sometimes(len(q.costs) > 1, "Jobs wait behind other jobs")
+}
+
+func (q *Queue) Pop() (cost int, ok bool) {
+	if len(q.costs) == 0 {
+		invariant.Reachable("Popping an empty queue")
+		return 0, false
+	}
+	cost, q.costs = q.costs[0], q.costs[1:]
+	if err := dropJob(); err != nil {
+		return q.Pop()
+	}
+	return cost, true
+}
+
+func (q *Queue) Len() int {
+	return len(q.costs)
+}
@@ -0,0 +1,31 @@
+// Package service shows a test that tracks the assertions of a package and its subpackages by
+// registering "./..." for analysis.
+package service
+
+import (
+	"github.com/james-orcales/golang_snacks/invariant"
+	"github.com/james-orcales/golang_snacks/invariant/examples/03_service/queue"
+)
+
+// drainOrder is a package-level property, so it is declared before the test registers the package
+// for analysis.
+var drainOrder = invariant.NeverAfter("Pop", "Empty", "Nothing is popped from an empty queue")
+
+// Drain pops every job from q and returns the sum of their costs.
+func Drain(q *queue.Queue) int {
+	timeline := invariant.NewTimeline(drainOrder)
+	total := 0
+	// One iteration per job, one for the pop that finds the queue empty and the last one that Until
+	// reserves for reporting a runaway loop.
+	for range invariant.Until(q.Len() + 2) {
+		cost, ok := q.Pop()
+		if !ok {
+			timeline.Emit("Empty")
+			break
+		}
+		timeline.Emit("Pop")
+		total += cost
+	}
+	invariant.AlwaysEqual(q.Len(), 0, "Draining empties the queue")
+	return total
+}
@@ -0,0 +1,23 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+	service "github.com/james-orcales/golang_snacks/invariant/examples/03_service"
+	"github.com/james-orcales/golang_snacks/invariant/examples/03_service/queue"
+)
+
+func TestMain(m *testing.M) {
+	invariant.RunTestMain(m, "./...")
+}
+
+func TestDrain(t *testing.T) {
+	for range 64 {
+		q := &queue.Queue{}
+		for cost := range 4 {
+			q.Push(cost)
+		}
+		service.Drain(q)
+	}
+}
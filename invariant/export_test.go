@@ -0,0 +1,7 @@
+package invariant
+
+// The external tests call these directly.
+var (
+	ExpandPackagePattern = expandPackagePattern
+	PackageFiles         = packageFiles
+)
@@ -0,0 +1,250 @@
+package invariant
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FailureMode determines what happens after an assertion fails.
+type FailureMode uint8
+
+const (
+	// FailurePanic panics with the *AssertionFailure. This is the default.
+	FailurePanic FailureMode = iota
+	// FailureExit prints the stack trace and exits with status 1.
+	FailureExit
+	// FailureLog logs the failure through FailurePolicy.Logger and continues.
+	FailureLog
+	// FailureCount counts the failure and continues. The counts are printed every
+	// FailurePolicy.SummaryInterval and by FlushFailureSummary.
+	FailureCount
+)
+
+func (mode FailureMode) String() string {
+	switch mode {
+	case FailurePanic:
+		return "panic"
+	case FailureExit:
+		return "exit"
+	case FailureLog:
+		return "log"
+	case FailureCount:
+		return "count"
+	}
+	return fmt.Sprintf("FailureMode(%d)", uint8(mode))
+}
+
+// FailureLogger is implemented by *itlog.Logger.
+type FailureLogger interface {
+	LogAssertionFailure(failure *AssertionFailure)
+}
+
+// FailureRule overrides the mode of the assertions that it matches.
+type FailureRule struct {
+	// Package is the import path of the package that contains the assertion. A trailing "/..."
+	// also matches its subpackages. Empty matches every package.
+	Package string
+	// Kind is AssertionFailure.Kind. A trailing "*" matches a family of assertions, such as
+	// "XAlways*". Empty matches every kind.
+	Kind string
+	Mode FailureMode
+}
+
+// FailurePolicy selects the FailureMode of every assertion. Production services can downgrade
+// specific packages or assertion families to FailureLog or FailureCount while the rest panic.
+//
+//	invariant.SetFailurePolicy(invariant.FailurePolicy{
+//		Mode: invariant.FailurePanic,
+//		Rules: []invariant.FailureRule{
+//			{Package: "example.com/service/cache/...", Mode: invariant.FailureLog},
+//			{Kind: "XAlways*", Mode: invariant.FailureCount},
+//		},
+//		Logger:          logger,
+//		SummaryInterval: time.Minute,
+//	})
+type FailurePolicy struct {
+	Mode FailureMode
+	// Rules are checked in order. The last matching rule wins.
+	Rules []FailureRule
+	// Logger is used by FailureLog. If nil, failures are printed to SummaryWriter.
+	Logger FailureLogger
+	// SummaryWriter receives the FailureCount summaries. If nil, os.Stderr is used.
+	SummaryWriter io.Writer
+	// SummaryInterval is how often FailureCount summaries are printed. If zero, they are only
+	// printed by FlushFailureSummary.
+	SummaryInterval time.Duration
+}
+
+// ModeFor returns the mode of the assertions of kind inside of pkg.
+func (policy FailurePolicy) ModeFor(pkg, kind string) FailureMode {
+	mode := policy.Mode
+	for _, rule := range policy.Rules {
+		if rule.matches(pkg, kind) {
+			mode = rule.Mode
+		}
+	}
+	return mode
+}
+
+func (rule FailureRule) matches(pkg, kind string) bool {
+	switch {
+	case rule.Package == "":
+	case strings.HasSuffix(rule.Package, "/..."):
+		parent := strings.TrimSuffix(rule.Package, "/...")
+		if pkg != parent && !strings.HasPrefix(pkg, parent+"/") {
+			return false
+		}
+	case rule.Package != pkg:
+		return false
+	}
+	switch {
+	case rule.Kind == "":
+	case strings.HasSuffix(rule.Kind, "*"):
+		if !strings.HasPrefix(kind, strings.TrimSuffix(rule.Kind, "*")) {
+			return false
+		}
+	case rule.Kind != kind:
+		return false
+	}
+	return true
+}
+
+var (
+	failurePolicy atomic.Pointer[FailurePolicy]
+	// stopFailureSummaries stops the goroutine of the previous policy.
+	stopFailureSummaries chan struct{}
+	failurePolicyMutex   sync.Mutex
+
+	failureCounts      = make(map[string]*failureCount)
+	failureCountsMutex sync.Mutex
+)
+
+type failureCount struct {
+	Kind    string
+	Message string
+	Count   int
+}
+
+func init() {
+	failurePolicy.Store(&FailurePolicy{})
+}
+
+// SetFailurePolicy replaces the current policy. It is safe to call while assertions are running.
+func SetFailurePolicy(policy FailurePolicy) {
+	failurePolicyMutex.Lock()
+	defer failurePolicyMutex.Unlock()
+	failurePolicy.Store(&policy)
+	if stopFailureSummaries != nil {
+		close(stopFailureSummaries)
+		stopFailureSummaries = nil
+	}
+	if policy.SummaryInterval <= 0 {
+		return
+	}
+	stop := make(chan struct{})
+	stopFailureSummaries = stop
+	go func() {
+		ticker := time.NewTicker(policy.SummaryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				FlushFailureSummary()
+			}
+		}
+	}()
+}
+
+func CurrentFailurePolicy() FailurePolicy {
+	return *failurePolicy.Load()
+}
+
+// FlushFailureSummary prints the failures counted under FailureCount since the last summary and
+// resets the counts. Nothing is printed if there were none. Call it before shutting down so that
+// the last interval isn't lost.
+func FlushFailureSummary() {
+	failureCountsMutex.Lock()
+	counts := failureCounts
+	failureCounts = make(map[string]*failureCount)
+	failureCountsMutex.Unlock()
+	if len(counts) == 0 {
+		return
+	}
+
+	locations := make([]string, 0, len(counts))
+	total := 0
+	longestKindWord := 0
+	longestMessageLength := 0
+	for location, count := range counts {
+		locations = append(locations, location)
+		total += count.Count
+		longestKindWord = max(longestKindWord, len(count.Kind))
+		longestMessageLength = max(longestMessageLength, len(count.Message))
+	}
+	sort.Strings(locations)
+
+	w := CurrentFailurePolicy().SummaryWriter
+	if w == nil {
+		w = os.Stderr
+	}
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "🚨 %d assertion failures since the last summary. 🚨\n", total)
+	for _, location := range locations {
+		count := counts[location]
+		fmt.Fprintf(
+			buf,
+			"\tcount=%-4d | %*s | %-*s | %s\n",
+			count.Count,
+			longestKindWord, count.Kind,
+			longestMessageLength, count.Message,
+			location,
+		)
+	}
+	io.WriteString(w, buf.String())
+}
+
+// handleAssertionFailure applies the current policy. It returns if the mode continues execution.
+func handleAssertionFailure(failure *AssertionFailure) {
+	policy := CurrentFailurePolicy()
+	mode := policy.ModeFor(failure.Package, failure.Kind)
+	if mode == FailurePanic && AssertionFailureIsFatal {
+		mode = FailureExit
+	}
+	switch mode {
+	case FailureExit:
+		fmt.Fprint(os.Stderr, failure.Stack)
+		fmt.Fprintln(os.Stderr, failure.Error())
+		os.Exit(1)
+	case FailureLog:
+		if policy.Logger != nil {
+			policy.Logger.LogAssertionFailure(failure)
+			return
+		}
+		w := policy.SummaryWriter
+		if w == nil {
+			w = os.Stderr
+		}
+		fmt.Fprintf(w, "%s | %s\n", failure.Error(), failure.Location)
+	case FailureCount:
+		failureCountsMutex.Lock()
+		count, ok := failureCounts[failure.Location]
+		if !ok {
+			// Only the first line since messages of temporal properties include a trace.
+			message, _, _ := strings.Cut(failure.Message, "\n")
+			count = &failureCount{Kind: failure.Kind, Message: message}
+			failureCounts[failure.Location] = count
+		}
+		count.Count++
+		failureCountsMutex.Unlock()
+	default:
+		panic(failure)
+	}
+}
@@ -1,9 +1,13 @@
 package invariant
 
 import (
+	"bytes"
 	"fmt"
 	"iter"
 	"os"
+	"reflect"
+	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -11,24 +15,19 @@ import (
 )
 
 const (
-	// Used to detect panics caused by assertion failures
-	//
-	//	defer func() {
-	//		if err := recover(); err != nil {
-	//			if strErr, ok := err.(string); ok && strings.HasPrefix(strErr, invariant.AssertionFailureMsgPrefix) {
-	//				// handle assertion failure
-	//			}
-	//		}
-	//	}()
+	// fuzzWorkerReportDirEnv is the directory shared between fuzz workers and their parent.
+	fuzzWorkerReportDirEnv = "INVARIANT_FUZZ_WORKER_REPORT_DIR"
+
+	// AssertionFailureMsgPrefix starts the error message of every AssertionFailure. To detect
+	// panics caused by assertion failures, use Recover or Catch instead of matching it.
 	AssertionFailureMsgPrefix = "🚨 Assertion Failure 🚨"
 )
 
-// TODO: AssertionFailure recover helper function
-
 var (
-	// msg is already prefixed with AssertionFailurePrefix here. If the user msg is empty then
-	// it also contains emptyMessageIndicator.
-	AssertionFailureHook    = func(msg string) {}
+	// msg is AssertionFailure.Error(), which is prefixed with AssertionFailureMsgPrefix.
+	AssertionFailureHook = func(msg string) {}
+	// Deprecated: Set FailurePolicy.Mode to FailureExit instead. If true, assertions that would
+	// panic exit instead.
 	AssertionFailureIsFatal = false
 
 	IsRunningUnderGoTest = func() bool {
@@ -53,6 +52,28 @@ var (
 		return v
 	}()
 
+	// Fuzz workers are child processes of the `go test -fuzz` coordinator. They also have
+	// IsRunningUnderGoFuzz set.
+	IsRunningUnderGoFuzzWorker = func() bool {
+		v := false
+		for _, arg := range os.Args {
+			if strings.HasPrefix(arg, "-test.fuzzworker") {
+				v = true
+				break
+			}
+		}
+		return v
+	}()
+
+	// invariantFunctionPrefix is the prefix of the fully qualified names of this package's
+	// functions, such as "github.com/james-orcales/golang_snacks/invariant.".
+	invariantFunctionPrefix = func() string {
+		pc, _, _, _ := runtime.Caller(0)
+		name := runtime.FuncForPC(pc).Name()
+		slash := strings.LastIndexByte(name, '/')
+		return name[:slash+strings.IndexByte(name[slash:], '.')+1]
+	}()
+
 	IsRunningUnderGoBenchmark = func() bool {
 		v := false
 		for _, arg := range os.Args {
@@ -65,24 +86,130 @@ var (
 	}()
 )
 
+// RunTestMain registers dirs for analysis, runs the tests, then fails the run if any assertion
+// was never true. If ReportDirEnv is set, the tracker is written there as a Report instead and
+// misses don't fail the run, which is left to `invariant check` on the merged reports.
 func RunTestMain(m *testing.M, dirs ...string) {
-	RegisterPackagesForAnalysis(dirs...)
+	os.Exit(RunTests(m, TestOptions{Dirs: dirs}).Code)
+}
+
+// RunTests is RunTestMain configured by options. It returns instead of exiting so that TestMain
+// can clean up before passing the Code to os.Exit. The reports written under ReportDirEnv store
+// the MinFrequency and KnownMisses of options so that `invariant check` enforces them.
+func RunTests(m *testing.M, options TestOptions) TestResult {
+	RegisterPackagesForAnalysis(options.Dirs...)
 	code := m.Run()
-	AnalyzeAssertionFrequency()
-	os.Exit(code)
+	if dir := os.Getenv(ReportDirEnv); dir != "" {
+		if _, err := writeTestReport(dir, options); err != nil {
+			fmt.Fprintf(os.Stderr, "Writing assertion report: %s\n", err)
+			code = max(code, 1)
+		}
+	}
+	result := analyzeAssertionFrequency(options)
+	result.Code = max(result.Code, code)
+	return result
+}
+
+// AssertionFailure is the panic value of failed assertions.
+type AssertionFailure struct {
+	Message string
+	// Kind is the assertion that failed, such as Always or Timeline.Emit.
+	Kind string
+	// Location is the file:line of the failed assertion call.
+	Location string
+	// Package is the import path of the package that contains the assertion call.
+	Package string
+	// Stack starts at the failed assertion and is formatted by xdebug.FprintStackTrace.
+	Stack string
+}
+
+func (failure *AssertionFailure) Error() string {
+	return AssertionFailureMsgPrefix + ": " + failure.Message
+}
+
+// Recover converts an assertion failure panic into an error. Other panics are propagated. It must
+// be deferred directly:
+//
+//	func handle(req Request) (err error) {
+//		defer invariant.Recover(&err)
+//		...
+//	}
+func Recover(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	failure, ok := r.(*AssertionFailure)
+	if !ok {
+		panic(r)
+	}
+	*err = failure
+}
+
+// Catch calls fn and returns the *AssertionFailure that it panicked with, if any. Other panics are
+// propagated.
+func Catch(fn func()) (err error) {
+	defer Recover(&err)
+	fn()
+	return nil
 }
 
-// WARN: Callers rely on this callback to implicitly terminate control flow on failure (via
-// panic or os.Exit).
+// assertionFailureCallback handles the failure according to the FailurePolicy.
+//
+// WARN: Under FailurePanic and FailureExit, callers rely on this callback to terminate control
+// flow. Under FailureLog and FailureCount it returns, so callers must still return right after
+// calling it.
 func assertionFailureCallback(msg string) {
-	AssertionFailureHook(msg)
-	if AssertionFailureIsFatal {
-		xdebug.FprintStackTrace(os.Stderr, 1)
-		fmt.Fprintln(os.Stderr, msg)
-		os.Exit(1)
-	} else {
-		panic(msg)
+	failure := newAssertionFailure(msg)
+	AssertionFailureHook(failure.Error())
+	handleAssertionFailure(failure)
+}
+
+// newAssertionFailure must only be called by assertionFailureCallback. The assertion is the
+// outermost frame of this package and its call site is the frame after it.
+func newAssertionFailure(msg string) *AssertionFailure {
+	failure := &AssertionFailure{Message: msg}
+	callers := [xdebug.StackTraceDepth]uintptr{}
+	n := runtime.Callers(3, callers[:])
+	frames := runtime.CallersFrames(callers[:n])
+	depth := 0
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, invariantFunctionPrefix) {
+			failure.Location = frame.File + ":" + strconv.Itoa(frame.Line)
+			slash := max(0, strings.LastIndexByte(frame.Function, '/'))
+			if dot := strings.IndexByte(frame.Function[slash:], '.'); dot >= 0 {
+				failure.Package = frame.Function[:slash+dot]
+			}
+			break
+		}
+		failure.Kind = assertionKind(strings.TrimPrefix(frame.Function, invariantFunctionPrefix))
+		depth++
+		if !more {
+			break
+		}
+	}
+
+	stack := &strings.Builder{}
+	xdebug.FprintStackTrace(stack, 2+max(0, depth-1))
+	failure.Stack = stack.String()
+	return failure
+}
+
+// assertionKind formats function names such as `(*Timeline).Emit` and `Until[...].func1` as
+// Timeline.Emit and Until.
+func assertionKind(function string) string {
+	if i := strings.IndexByte(function, '['); i >= 0 {
+		if j := strings.IndexByte(function[i:], ']'); j >= 0 {
+			function = function[:i] + function[i+j+1:]
+		}
 	}
+	function = strings.NewReplacer("(*", "", "(", "", ")", "").Replace(function)
+	parts := strings.Split(function, ".")
+	for len(parts) > 1 && strings.HasPrefix(parts[len(parts)-1], "func") {
+		parts = parts[:len(parts)-1]
+	}
+	return strings.Join(parts, ".")
 }
 
 // The same as Always but is enabled regardless of any build tag provided.
@@ -115,3 +242,43 @@ func GameLoop() iter.Seq[int] {
 		}
 	}
 }
+
+// Invariants is implemented by types that validate their whole structure, such as a tree that
+// checks its ordering and balance. CheckAround and XAlwaysValid report the returned error as an
+// assertion failure so that the validation is written once instead of in every method.
+//
+//	func (tree *Tree) Invariants() error {
+//		if tree.root != nil && tree.root.parent != nil {
+//			return errors.New("the root has a parent")
+//		}
+//		return tree.root.checkOrder()
+//	}
+type Invariants interface {
+	Invariants() error
+}
+
+// Frozen is a deep copy of the values behind some pointers, taken by Freeze and compared to their
+// current values by XAlwaysUnchanged. It is empty under disable_assertions and
+// disable_x_assertions.
+type Frozen struct {
+	pointers []reflect.Value
+	copies   []reflect.Value
+}
+
+// Goroutine identifies a goroutine for AlwaysOwnedBy and HappensBefore.
+type Goroutine uint64
+
+// CurrentGoroutine returns the goroutine that calls it. It parses the header of the goroutine's
+// stack trace, so keep it out of hot paths outside of assertions.
+func CurrentGoroutine() Goroutine {
+	buf := [64]byte{}
+	header := buf[:runtime.Stack(buf[:], false)]
+	// goroutine 18 [running]:
+	header = bytes.TrimPrefix(header, []byte("goroutine "))
+	if i := bytes.IndexByte(header, ' '); i >= 0 {
+		header = header[:i]
+	}
+	id, err := strconv.ParseUint(string(header), 10, 64)
+	Always(err == nil, "The stack trace starts with the goroutine ID")
+	return Goroutine(id)
+}
@@ -0,0 +1,538 @@
+package invariant
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ReportDirEnv is the directory where RunTestMain writes a report at the end of every test
+	// run. Each run creates a new file so that `go test ./...` and CI shards can share the same
+	// directory. Missed assertions don't fail the runs since another package may cover them, so
+	// check the merged report, which enforces the thresholds and known misses of RunTests:
+	//
+	//	INVARIANT_REPORT_DIR=/tmp/invariant go test ./...
+	//	go run ./invariant/cmd/invariant merge -o merged.json /tmp/invariant
+	//	go run ./invariant/cmd/invariant check merged.json
+	ReportDirEnv = "INVARIANT_REPORT_DIR"
+	// ReportVersion is bumped on breaking changes to the report format.
+	ReportVersion = 1
+)
+
+// Report is the persisted form of the assertion tracker.
+type Report struct {
+	Version int
+	// Module is the module path declared in go.mod. Reports of different modules can't be
+	// merged since their locations would collide.
+	Module     string
+	Assertions []ReportEntry
+}
+
+type ReportEntry struct {
+	// ID is the static ID of the assertion. Refer to AssertionID. It is zero in reports written
+	// before IDs were introduced.
+	ID uint64 `json:",omitempty"`
+	// Location is file:line, where file is slash-separated and relative to the module root so
+	// that reports from different checkouts can be merged.
+	Location  string
+	Kind      string
+	Message   string
+	Frequency int
+	// Tests that called AttributeTest and evaluated the assertion as true, sorted by name.
+	Tests []string `json:",omitempty"`
+
+	// Evaluations counts both true and false evaluations. It is only tracked for ProbablyRatio.
+	Evaluations int `json:",omitempty"`
+	// MinFrequency is the threshold of SometimesCount, or of the Kind in TestOptions.MinFrequency.
+	MinFrequency int `json:",omitempty"`
+	// RatioLo and RatioHi bound the fraction of true evaluations of ProbablyRatio.
+	RatioLo float64 `json:",omitempty"`
+	RatioHi float64 `json:",omitempty"`
+	// KnownMiss is the entry of TestOptions.KnownMisses that allows the assertion to be missed,
+	// so that `invariant check` allows it too.
+	KnownMiss *KnownMiss `json:",omitempty"`
+}
+
+// IsMissed reports whether the assertion never evaluated to true. ProbablyRatio is only missed if
+// it was never evaluated since its band may include zero.
+func (entry ReportEntry) IsMissed() bool {
+	if entry.Kind == "ProbablyRatio" {
+		return entry.Evaluations == 0
+	}
+	return entry.Frequency == 0
+}
+
+// Violation describes how the assertion violated its threshold. It is empty if the assertion has
+// no threshold, met it, or was missed.
+func (entry ReportEntry) Violation() string {
+	switch entry.Kind {
+	case "ProbablyRatio":
+		if entry.Evaluations == 0 {
+			return ""
+		}
+		ratio := float64(entry.Frequency) / float64(entry.Evaluations)
+		if ratio < entry.RatioLo || ratio > entry.RatioHi {
+			return fmt.Sprintf("true in %.3f of %d evaluations, expected [%g, %g]", ratio, entry.Evaluations, entry.RatioLo, entry.RatioHi)
+		}
+	default:
+		if entry.Frequency > 0 && entry.Frequency < entry.MinFrequency {
+			return fmt.Sprintf("true %d times, expected at least %d", entry.Frequency, entry.MinFrequency)
+		}
+	}
+	return ""
+}
+
+// FileLine splits the location of the assertion. The line is zero if the location is malformed.
+func (entry ReportEntry) FileLine() (file string, line int) {
+	return splitLocation(entry.Location)
+}
+
+// Missed returns the assertions that never evaluated to true, sorted by location.
+func (report Report) Missed() []ReportEntry {
+	missed := make([]ReportEntry, 0, len(report.Assertions))
+	for _, entry := range report.Assertions {
+		if entry.IsMissed() {
+			missed = append(missed, entry)
+		}
+	}
+	return missed
+}
+
+// Violations returns the assertions that violated their thresholds, sorted by location.
+func (report Report) Violations() []ReportEntry {
+	var violations []ReportEntry
+	for _, entry := range report.Assertions {
+		if entry.Violation() != "" {
+			violations = append(violations, entry)
+		}
+	}
+	return violations
+}
+
+// UniquelyCovered maps each test to the assertions that no other attributed test exercised. These
+// are the assertions that go dark when the test is removed. Tests that uniquely cover nothing are
+// omitted.
+func (report Report) UniquelyCovered() map[string][]ReportEntry {
+	covered := make(map[string][]ReportEntry)
+	for _, entry := range report.Assertions {
+		if len(entry.Tests) == 1 {
+			covered[entry.Tests[0]] = append(covered[entry.Tests[0]], entry)
+		}
+	}
+	return covered
+}
+
+// MergeReports combines reports by ID, summing their frequencies. Entries without an ID are
+// combined by location. An assertion counts as covered if any of the reports exercised it, even if
+// it moved to another line between the reports.
+func MergeReports(reports ...Report) (Report, error) {
+	merged := Report{Version: ReportVersion}
+	index := make(map[string]int)
+	key := reportEntryKey
+	for _, report := range reports {
+		if report.Version != ReportVersion {
+			return Report{}, fmt.Errorf("unsupported report version %d, expected %d", report.Version, ReportVersion)
+		}
+		if merged.Module == "" {
+			merged.Module = report.Module
+		} else if report.Module != merged.Module {
+			return Report{}, fmt.Errorf("can't merge reports of different modules: %q and %q", merged.Module, report.Module)
+		}
+		for _, entry := range report.Assertions {
+			if i, ok := index[key(entry)]; ok {
+				// The latest location wins since reports are usually given oldest first.
+				merged.Assertions[i].Frequency += entry.Frequency
+				merged.Assertions[i].Location = entry.Location
+				merged.Assertions[i].Tests = unionTests(merged.Assertions[i].Tests, entry.Tests)
+				merged.Assertions[i].Evaluations += entry.Evaluations
+				merged.Assertions[i].MinFrequency = max(merged.Assertions[i].MinFrequency, entry.MinFrequency)
+				if entry.Evaluations > 0 {
+					merged.Assertions[i].RatioLo, merged.Assertions[i].RatioHi = entry.RatioLo, entry.RatioHi
+				}
+				if entry.KnownMiss != nil && (merged.Assertions[i].KnownMiss == nil || entry.KnownMiss.outlives(*merged.Assertions[i].KnownMiss)) {
+					merged.Assertions[i].KnownMiss = entry.KnownMiss
+				}
+				continue
+			}
+			index[key(entry)] = len(merged.Assertions)
+			merged.Assertions = append(merged.Assertions, entry)
+		}
+	}
+	sortReportEntries(merged.Assertions)
+	return merged, nil
+}
+
+// reportEntryKey identifies the same assertion across reports. Refer to MergeReports.
+func reportEntryKey(entry ReportEntry) string {
+	if entry.ID != 0 {
+		return strconv.FormatUint(entry.ID, 16)
+	}
+	return entry.Location
+}
+
+// ReportDiff is the change in coverage from an old report to a new one.
+type ReportDiff struct {
+	// NewlyMissed were true in the old report but never in the new one. Each pair is the old
+	// entry followed by the new entry.
+	NewlyMissed [][2]ReportEntry
+	// Dropped are still covered but their frequency dropped by more than the threshold.
+	Dropped [][2]ReportEntry
+	// Added are only in the new report and Removed are only in the old report.
+	Added   []ReportEntry
+	Removed []ReportEntry
+}
+
+// IsRegression reports whether any assertion lost coverage. Added assertions that are missed are
+// left to the missed-invariant analysis of the new report.
+func (diff ReportDiff) IsRegression() bool {
+	return len(diff.NewlyMissed) > 0 || len(diff.Dropped) > 0
+}
+
+// DiffReports compares reports of the same module. Assertions are matched like in MergeReports so
+// that moving an assertion to another line doesn't count as removing it. An assertion has dropped
+// if its new frequency is below (1-threshold) of its old frequency, so a threshold of 0.5 reports
+// assertions that were exercised less than half as often.
+func DiffReports(oldReport, newReport Report, threshold float64) (ReportDiff, error) {
+	if oldReport.Version != ReportVersion || newReport.Version != ReportVersion {
+		return ReportDiff{}, fmt.Errorf("unsupported report versions %d and %d, expected %d", oldReport.Version, newReport.Version, ReportVersion)
+	}
+	if oldReport.Module != newReport.Module {
+		return ReportDiff{}, fmt.Errorf("can't diff reports of different modules: %q and %q", oldReport.Module, newReport.Module)
+	}
+	if threshold < 0 || threshold > 1 {
+		return ReportDiff{}, fmt.Errorf("the threshold is a fraction between 0 and 1. got %g", threshold)
+	}
+	var diff ReportDiff
+	olds := make(map[string]ReportEntry, len(oldReport.Assertions))
+	for _, entry := range oldReport.Assertions {
+		olds[reportEntryKey(entry)] = entry
+	}
+	for _, entry := range newReport.Assertions {
+		key := reportEntryKey(entry)
+		before, ok := olds[key]
+		if !ok {
+			diff.Added = append(diff.Added, entry)
+			continue
+		}
+		delete(olds, key)
+		switch {
+		case before.IsMissed():
+		case entry.IsMissed():
+			diff.NewlyMissed = append(diff.NewlyMissed, [2]ReportEntry{before, entry})
+		case float64(entry.Frequency) < float64(before.Frequency)*(1-threshold):
+			diff.Dropped = append(diff.Dropped, [2]ReportEntry{before, entry})
+		}
+	}
+	for _, entry := range olds {
+		diff.Removed = append(diff.Removed, entry)
+	}
+	sortReportEntries(diff.Added)
+	sortReportEntries(diff.Removed)
+	return diff, nil
+}
+
+// Flakiness classifies the assertions of several runs of the same suite by how many of the runs
+// covered them.
+type Flakiness struct {
+	Runs int
+	// AlwaysCovered were covered by every run, Flaky by some of them and NeverCovered by none.
+	AlwaysCovered []FlakyEntry
+	Flaky         []FlakyEntry
+	NeverCovered  []FlakyEntry
+}
+
+// FlakyEntry is an assertion merged across runs.
+type FlakyEntry struct {
+	ReportEntry
+	// Covered is the number of runs that didn't miss the assertion. An assertion that is absent
+	// from a run, such as one behind a build tag, counts as missed in it.
+	Covered int
+	// Probability estimates the chance that a single run covers the assertion.
+	Probability float64
+}
+
+// ClassifyFlakiness compares reports of separate runs of the same suite. A probabilistic Sometimes
+// is flaky with a high probability while a real gap in the tests is never covered. Assertions are
+// matched like in MergeReports.
+func ClassifyFlakiness(reports ...Report) (Flakiness, error) {
+	if len(reports) == 0 {
+		return Flakiness{}, fmt.Errorf("expected at least one report")
+	}
+	merged, err := MergeReports(reports...)
+	if err != nil {
+		return Flakiness{}, err
+	}
+	covered := make(map[string]int, len(merged.Assertions))
+	for _, report := range reports {
+		for _, entry := range report.Assertions {
+			if !entry.IsMissed() {
+				covered[reportEntryKey(entry)]++
+			}
+		}
+	}
+	flakiness := Flakiness{Runs: len(reports)}
+	for _, entry := range merged.Assertions {
+		n := covered[reportEntryKey(entry)]
+		flaky := FlakyEntry{ReportEntry: entry, Covered: n, Probability: float64(n) / float64(len(reports))}
+		switch n {
+		case len(reports):
+			flakiness.AlwaysCovered = append(flakiness.AlwaysCovered, flaky)
+		case 0:
+			flakiness.NeverCovered = append(flakiness.NeverCovered, flaky)
+		default:
+			flakiness.Flaky = append(flakiness.Flaky, flaky)
+		}
+	}
+	// The least likely assertions are listed first since they fail the most runs.
+	sort.SliceStable(flakiness.Flaky, func(i, j int) bool {
+		return flakiness.Flaky[i].Covered < flakiness.Flaky[j].Covered
+	})
+	return flakiness, nil
+}
+
+func ReadReport(path string) (Report, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Report{}, err
+	}
+	defer file.Close()
+
+	var report Report
+	if err := json.NewDecoder(bufio.NewReader(file)).Decode(&report); err != nil {
+		return Report{}, fmt.Errorf("decoding report %s: %w", path, err)
+	}
+	return report, nil
+}
+
+// ReadReports reads every path as a report. Directories are expanded to the .json files directly
+// inside of them.
+func ReadReports(paths ...string) ([]Report, error) {
+	reports := make([]Report, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			report, err := ReadReport(path)
+			if err != nil {
+				return nil, err
+			}
+			reports = append(reports, report)
+			continue
+		}
+		files, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			report, err := ReadReport(file)
+			if err != nil {
+				return nil, err
+			}
+			reports = append(reports, report)
+		}
+	}
+	return reports, nil
+}
+
+func WriteReport(w io.Writer, report Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(report)
+}
+
+// FprintMissed lists the assertions that were never true in the same layout as
+// AnalyzeAssertionFrequency.
+func FprintMissed(w io.Writer, missed []ReportEntry) {
+	longestKindWord := 0
+	longestMessageLength := 0
+	for _, entry := range missed {
+		longestKindWord = max(longestKindWord, len(entry.Kind))
+		longestMessageLength = max(longestMessageLength, len(entry.Message))
+	}
+	fmt.Fprintf(w, "🚨 %d assertions were never true. 🚨\n", len(missed))
+	for _, entry := range missed {
+		fmt.Fprintf(
+			w,
+			"\t%*s | %-*s | %s\n",
+			longestKindWord, entry.Kind,
+			longestMessageLength, entry.Message,
+			entry.Location,
+		)
+	}
+}
+
+// FprintViolations lists the assertions that violated their thresholds in the same layout as
+// FprintMissed.
+func FprintViolations(w io.Writer, violations []ReportEntry) {
+	longestKindWord := 0
+	longestMessageLength := 0
+	for _, entry := range violations {
+		longestKindWord = max(longestKindWord, len(entry.Kind))
+		longestMessageLength = max(longestMessageLength, len(entry.Message))
+	}
+	fmt.Fprintf(w, "🚨 %d assertions violated their thresholds. 🚨\n", len(violations))
+	for _, entry := range violations {
+		fmt.Fprintf(
+			w,
+			"\t%*s | %-*s | %s | %s\n",
+			longestKindWord, entry.Kind,
+			longestMessageLength, entry.Message,
+			entry.Location,
+			entry.Violation(),
+		)
+	}
+}
+
+// FprintFlakiness lists the flaky and never covered assertions in the same layout as FprintMissed.
+func FprintFlakiness(w io.Writer, flakiness Flakiness) {
+	longestKindWord := 0
+	longestMessageLength := 0
+	for _, entries := range [...][]FlakyEntry{flakiness.Flaky, flakiness.NeverCovered} {
+		for _, entry := range entries {
+			longestKindWord = max(longestKindWord, len(entry.Kind))
+			longestMessageLength = max(longestMessageLength, len(entry.Message))
+		}
+	}
+	fmt.Fprintf(w, "%d of %d assertions were covered by all %d runs.\n", len(flakiness.AlwaysCovered), len(flakiness.AlwaysCovered)+len(flakiness.Flaky)+len(flakiness.NeverCovered), flakiness.Runs)
+	if len(flakiness.Flaky) > 0 {
+		fmt.Fprintf(w, "⚠️ %d assertions were only covered by some runs. ⚠️\n", len(flakiness.Flaky))
+		for _, entry := range flakiness.Flaky {
+			fmt.Fprintf(
+				w,
+				"\tp=%.2f %d/%d | %*s | %-*s | %s\n",
+				entry.Probability, entry.Covered, flakiness.Runs,
+				longestKindWord, entry.Kind,
+				longestMessageLength, entry.Message,
+				entry.Location,
+			)
+		}
+	}
+	if len(flakiness.NeverCovered) > 0 {
+		fmt.Fprintf(w, "🚨 %d assertions were never covered. 🚨\n", len(flakiness.NeverCovered))
+		for _, entry := range flakiness.NeverCovered {
+			fmt.Fprintf(
+				w,
+				"\t%*s | %-*s | %s\n",
+				longestKindWord, entry.Kind,
+				longestMessageLength, entry.Message,
+				entry.Location,
+			)
+		}
+	}
+}
+
+// FprintAttribution lists the tests of every assertion followed by the assertions that each test
+// uniquely covers.
+func FprintAttribution(w io.Writer, report Report) {
+	fmt.Fprintln(w, "Tests that exercised each assertion:")
+	for _, entry := range report.Assertions {
+		fmt.Fprintf(w, "\t%s | %s | %s\n", entry.Location, entry.Kind, entry.Message)
+		if len(entry.Tests) == 0 {
+			fmt.Fprintln(w, "\t\t(no attributed tests)")
+		}
+		for _, test := range entry.Tests {
+			fmt.Fprintf(w, "\t\t%s\n", test)
+		}
+	}
+
+	covered := report.UniquelyCovered()
+	tests := make([]string, 0, len(covered))
+	for test := range covered {
+		tests = append(tests, test)
+	}
+	sort.Strings(tests)
+	fmt.Fprintln(w, "Assertions that only one test exercised:")
+	for _, test := range tests {
+		fmt.Fprintf(w, "\t%s\n", test)
+		for _, entry := range covered[test] {
+			fmt.Fprintf(w, "\t\t%s | %s | %s\n", entry.Location, entry.Kind, entry.Message)
+		}
+	}
+}
+
+func sortedTests(tests map[string]bool) []string {
+	if len(tests) == 0 {
+		return nil
+	}
+	sorted := make([]string, 0, len(tests))
+	for test := range tests {
+		sorted = append(sorted, test)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func unionTests(a, b []string) []string {
+	set := make(map[string]bool, len(a)+len(b))
+	for _, test := range a {
+		set[test] = true
+	}
+	for _, test := range b {
+		set[test] = true
+	}
+	return sortedTests(set)
+}
+
+func sortReportEntries(entries []ReportEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		fileI, lineI := splitLocation(entries[i].Location)
+		fileJ, lineJ := splitLocation(entries[j].Location)
+		if fileI != fileJ {
+			return fileI < fileJ
+		}
+		return lineI < lineJ
+	})
+}
+
+// splitLocation splits file:line. The line is zero if location is malformed.
+func splitLocation(location string) (file string, line int) {
+	i := strings.LastIndexByte(location, ':')
+	if i < 0 {
+		return location, 0
+	}
+	for _, ch := range location[i+1:] {
+		if ch < '0' || ch > '9' {
+			return location, 0
+		}
+		line = line*10 + int(ch-'0')
+	}
+	return location[:i], line
+}
+
+// FindModule walks up from dir until it finds a go.mod and returns its directory and declared
+// module path.
+func FindModule(dir string) (root, module string, err error) {
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	for {
+		content, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			for line := range strings.SplitSeq(string(content), "\n") {
+				if rest, ok := strings.CutPrefix(strings.TrimSpace(line), "module"); ok {
+					return dir, strings.Trim(strings.TrimSpace(rest), `"`), nil
+				}
+			}
+			return "", "", fmt.Errorf("%s has no module declaration", filepath.Join(dir, "go.mod"))
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", errors.New("not inside a Go module")
+		}
+		dir = parent
+	}
+}
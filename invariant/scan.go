@@ -0,0 +1,264 @@
+package invariant
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// assertionKinds are the functions that are tracked by the analyzer and their number of
+// parameters. All of them take the message as their last parameter.
+var assertionKinds = map[string]int{
+	"Sometimes":        2,
+	"XSometimes":       2,
+	"Ensure":           2,
+	"Always":           2,
+	"AlwaysNil":        2,
+	"AlwaysErrIs":      3,
+	"AlwaysErrIsNot":   3,
+	"XAlways":          2,
+	"XAlwaysNil":       2,
+	"XAlwaysErrIs":     3,
+	"XAlwaysErrIsNot":  3,
+	"XAlwaysValid":     2,
+	"XAlwaysUnchanged": 2,
+	"CheckAround":      3,
+	"AlwaysEqual":      3,
+	"AlwaysLess":       3,
+	"AlwaysInRange":    4,
+	"AlwaysLen":        3,
+	"AlwaysContains":   3,
+	"AlwaysDeepEqual":  3,
+	"Reachable":        1,
+	"SometimesCount":   3,
+	"ProbablyRatio":    4,
+	"AlwaysBefore":     3,
+	"NeverAfter":       3,
+	"EventuallyAfter":  4,
+
+	"AlwaysLocked":         2,
+	"AlwaysOwnedBy":        2,
+	"NotReentrant":         2,
+	"AlwaysHappenedBefore": 3,
+}
+
+// AssertionParameters returns the number of parameters of the assertion function name, or zero if
+// name isn't tracked by the analyzer.
+func AssertionParameters(name string) int {
+	return assertionKinds[name]
+}
+
+// AssertionSite is the source location of an assertion call.
+type AssertionSite struct {
+	// ID identifies the assertion independently of its line so that reports survive unrelated
+	// edits. Refer to AssertionID.
+	ID uint64
+	// File is relative to the generated table's directory in RegisterAssertionTable. Otherwise,
+	// it is the file name given to the parser.
+	File    string
+	Line    int
+	Kind    string
+	Message string
+}
+
+// ScanAssertions finds every assertion call in file. The qualifier must be `invariant`. pkg is the
+// slash-separated package directory relative to the module root, which is part of every ID.
+func ScanAssertions(fset *token.FileSet, file *ast.File, pkg string) ([]AssertionSite, error) {
+	var (
+		sites    []AssertionSite
+		err      error
+		ordinals = make(map[string]int)
+	)
+	for _, decl := range file.Decls {
+		// Package-level declarations and init functions aren't unique within a package.
+		scope := ""
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			scope = funcDeclName(fn)
+		}
+		if scope == "" || scope == "init" {
+			scope = filepath.Base(fset.Position(decl.Pos()).Filename) + ":" + scope
+		}
+		ast.Inspect(decl, func(n ast.Node) bool {
+			if err != nil {
+				return false
+			}
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			fun := call.Fun
+			// Explicit type arguments such as invariant.AlwaysEqual[int](...)
+			switch index := fun.(type) {
+			case *ast.IndexExpr:
+				fun = index.X
+			case *ast.IndexListExpr:
+				fun = index.X
+			}
+			sel, ok := fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			ident, ok := sel.X.(*ast.Ident)
+			if !ok || ident.Name != "invariant" {
+				return true
+			}
+			params, ok := assertionKinds[sel.Sel.Name]
+			if !ok {
+				return true
+			}
+
+			pos := fset.Position(call.Lparen)
+			if len(call.Args) != params {
+				err = fmt.Errorf("%s: invariant.%s has %d parameters", pos, sel.Sel.Name, params)
+				return false
+			}
+			literal, ok := call.Args[len(call.Args)-1].(*ast.BasicLit)
+			if !ok || literal.Kind != token.STRING {
+				err = fmt.Errorf("%s: the last parameter of invariant.%s is the message as a string literal", pos, sel.Sel.Name)
+				return false
+			}
+			msg, unquoteErr := strconv.Unquote(literal.Value)
+			if unquoteErr != nil {
+				err = fmt.Errorf("%s: %w", pos, unquoteErr)
+				return false
+			}
+
+			key := scope + "\x00" + sel.Sel.Name + "\x00" + msg
+			sites = append(sites, AssertionSite{
+				ID:      AssertionID(pkg, scope, sel.Sel.Name, msg, ordinals[key]),
+				File:    pos.Filename,
+				Line:    pos.Line,
+				Kind:    sel.Sel.Name,
+				Message: msg,
+			})
+			ordinals[key]++
+			return true
+		})
+	}
+	return sites, err
+}
+
+// AssertionID hashes everything that identifies an assertion except for its line. ordinal
+// distinguishes identical assertions within the same function. The ID is never zero.
+func AssertionID(pkg, function, kind, msg string, ordinal int) uint64 {
+	h := fnv.New64a()
+	for _, part := range [...]string{pkg, function, kind, msg, strconv.Itoa(ordinal)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return max(h.Sum64(), 1)
+}
+
+// funcDeclName formats methods as Type.Method regardless of the receiver being a pointer.
+func funcDeclName(decl *ast.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return decl.Name.Name
+	}
+	typ := decl.Recv.List[0].Type
+	for {
+		switch t := typ.(type) {
+		case *ast.StarExpr:
+			typ = t.X
+			continue
+		case *ast.IndexExpr:
+			typ = t.X
+			continue
+		case *ast.IndexListExpr:
+			typ = t.X
+			continue
+		case *ast.Ident:
+			return t.Name + "." + decl.Name.Name
+		}
+		return decl.Name.Name
+	}
+}
+
+// expandPackagePattern returns the absolute directories that pattern matches. pattern is a
+// directory relative to the working directory or an import path inside the module of the working
+// directory. A trailing "/..." also matches every subdirectory except for the ones that the go
+// command ignores: testdata, vendor, directories starting with "." or "_", and nested modules.
+func expandPackagePattern(pattern string) ([]string, error) {
+	dir, isRecursive := strings.CutSuffix(pattern, "/...")
+	if pattern == "..." {
+		dir, isRecursive = ".", true
+	}
+	if !build.IsLocalImport(dir) && !filepath.IsAbs(dir) {
+		if root, module, err := FindModule("."); err == nil {
+			if rest, ok := strings.CutPrefix(dir, module); ok && (rest == "" || rest[0] == '/') {
+				dir = filepath.Join(root, filepath.FromSlash(rest))
+			}
+		}
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !isRecursive {
+		return []string{dir}, nil
+	}
+	var dirs []string
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		if path != dir {
+			name := d.Name()
+			if name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+		}
+		dirs = append(dirs, path)
+		return nil
+	})
+	return dirs, err
+}
+
+// packageFiles returns the non-test Go files of dir that context builds. Files excluded from the
+// build, such as the disable_assertions stubs, would register assertions that can never run.
+func packageFiles(context build.Context, dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".go" || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if ok, err := context.MatchFile(dir, name); err != nil || !ok {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	return files, nil
+}
+
+// assertionBuildContext selects files like the build of the running binary, including its -tags.
+func assertionBuildContext() build.Context {
+	context := build.Default
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return context
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "-tags":
+			context.BuildTags = strings.Split(setting.Value, ",")
+		case "CGO_ENABLED":
+			context.CgoEnabled = setting.Value == "1"
+		}
+	}
+	return context
+}
@@ -0,0 +1,60 @@
+// Package telemetry exposes the assertion telemetry of invariant.SetTelemetry over HTTP. It is
+// separate from the invariant package so that services which don't use it aren't linked with
+// net/http.
+//
+// Either serve the snapshots:
+//
+//	invariant.SetTelemetry(invariant.Telemetry{SampleEvery: 64})
+//	http.Handle("/debug/invariant", telemetry.Handler())
+//
+// or send them to a collector:
+//
+//	invariant.SetTelemetry(invariant.Telemetry{
+//		SampleEvery: 64,
+//		Writer:      &telemetry.Poster{URL: "http://collector/invariant"},
+//		Interval:    time.Minute,
+//	})
+package telemetry
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+)
+
+// Handler responds with invariant.TelemetrySnapshot as a JSON Report.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := invariant.WriteReport(w, invariant.TelemetrySnapshot()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// Poster is a Telemetry.Writer that POSTs every snapshot to URL.
+type Poster struct {
+	URL string
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+func (poster *Poster) Write(report []byte) (int, error) {
+	client := poster.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(poster.URL, "application/json", bytes.NewReader(report))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return 0, fmt.Errorf("posting assertion telemetry to %s: %s", poster.URL, resp.Status)
+	}
+	return len(report), nil
+}
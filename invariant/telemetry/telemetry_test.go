@@ -0,0 +1,52 @@
+//go:build !disable_assertions
+
+package telemetry_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+	"github.com/james-orcales/golang_snacks/invariant/telemetry"
+)
+
+func TestTelemetry(t *testing.T) {
+	server := httptest.NewServer(telemetry.Handler())
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var report invariant.Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Version != invariant.ReportVersion {
+		t.Fatalf("Handler serves a report. got version %d", report.Version)
+	}
+
+	var posted []byte
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted, _ = io.ReadAll(r.Body)
+		if r.URL.Path == "/unavailable" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer collector.Close()
+	invariant.SetTelemetry(invariant.Telemetry{SampleEvery: 1, Writer: &telemetry.Poster{URL: collector.URL}})
+	defer invariant.SetTelemetry(invariant.Telemetry{})
+	if err := invariant.FlushTelemetry(); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(posted, &report); err != nil {
+		t.Fatalf("Poster sends the report: %s", err)
+	}
+
+	if _, err := (&telemetry.Poster{URL: collector.URL + "/unavailable"}).Write(posted); err == nil {
+		t.Fatal("Poster fails when the collector does")
+	}
+}
@@ -0,0 +1,25 @@
+//go:build disable_assertions
+
+package invariant
+
+import (
+	"io"
+	"time"
+)
+
+type Telemetry struct {
+	SampleEvery int
+	Writer      io.Writer
+	Interval    time.Duration
+}
+
+func SetTelemetry(config Telemetry) {
+}
+
+func FlushTelemetry() error {
+	return nil
+}
+
+func TelemetrySnapshot() Report {
+	return Report{Version: ReportVersion}
+}
@@ -0,0 +1,165 @@
+//go:build !disable_assertions
+
+package invariant
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Telemetry samples the true evaluations of assertions outside of tests so that a running service
+// shows which invariants real traffic exercises, as opposed to the tests. Every call site has an
+// atomic counter. Only sampled evaluations look up their call site, and they are counted
+// SampleEvery times so that the counters estimate the real frequencies.
+//
+// The snapshots are Reports, so `invariant diff` compares them with the reports of the tests.
+// Assertions are named by the tables of `invariant generate` and matched to the tests by their ID.
+// Other call sites only have a location and a kind. Build with -trimpath so that locations are
+// relative to the module root like in the reports of the tests.
+//
+//	invariant.SetTelemetry(invariant.Telemetry{
+//		SampleEvery: 64,
+//		Writer:      file,
+//		Interval:    time.Minute,
+//	})
+//	defer invariant.FlushTelemetry()
+type Telemetry struct {
+	// SampleEvery samples one in SampleEvery evaluations on average. One samples every
+	// evaluation and zero disables telemetry.
+	SampleEvery int
+	// Writer receives the snapshot as a JSON Report in a single Write. The
+	// invariant/telemetry package sends it to an HTTP endpoint instead.
+	Writer io.Writer
+	// Interval is how often snapshots are written. If zero, they are only written by
+	// FlushTelemetry.
+	Interval time.Duration
+}
+
+var (
+	telemetry            atomic.Pointer[Telemetry]
+	telemetrySampleEvery atomic.Int64
+	// stopTelemetrySnapshots stops the goroutine of the previous configuration.
+	stopTelemetrySnapshots chan struct{}
+	telemetryMutex         sync.Mutex
+)
+
+func init() {
+	telemetry.Store(&Telemetry{})
+}
+
+// SetTelemetry replaces the current configuration. It is safe to call while assertions are running.
+// The counters are kept across configurations.
+func SetTelemetry(config Telemetry) {
+	telemetryMutex.Lock()
+	defer telemetryMutex.Unlock()
+	Always(config.SampleEvery >= 0, "SampleEvery is never negative")
+	telemetry.Store(&config)
+	telemetrySampleEvery.Store(int64(config.SampleEvery))
+	// Call sites that were cached as untracked are now tracked.
+	resetAssertionCallers()
+	if stopTelemetrySnapshots != nil {
+		close(stopTelemetrySnapshots)
+		stopTelemetrySnapshots = nil
+	}
+	if config.SampleEvery == 0 || config.Interval <= 0 {
+		return
+	}
+	stop := make(chan struct{})
+	stopTelemetrySnapshots = stop
+	go func() {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := FlushTelemetry(); err != nil {
+					fmt.Fprintf(os.Stderr, "Writing assertion telemetry: %s\n", err)
+				}
+			}
+		}
+	}()
+}
+
+// FlushTelemetry writes a snapshot to the Writer of the current configuration. Call it before
+// shutting down so that the last interval isn't lost.
+func FlushTelemetry() error {
+	config := telemetry.Load()
+	if config.SampleEvery == 0 || config.Writer == nil {
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	if err := WriteReport(buf, TelemetrySnapshot()); err != nil {
+		return err
+	}
+	_, err := config.Writer.Write(buf.Bytes())
+	return err
+}
+
+// TelemetrySnapshot returns the counters of every tracked call site. The frequencies are
+// cumulative since the start of the process. Thresholds and test attribution are only tracked in
+// tests.
+func TelemetrySnapshot() Report {
+	module := ""
+	if info, ok := debug.ReadBuildInfo(); ok {
+		module = info.Main.Path
+	}
+	report := Report{Version: ReportVersion, Module: module}
+	assertionFrequencyMutex.Lock()
+	report.Assertions = make([]ReportEntry, 0, len(assertionTracker))
+	for location, metadata := range assertionTracker {
+		file, line := splitLocation(location)
+		file = filepath.ToSlash(file)
+		if rest, ok := strings.CutPrefix(file, module+"/"); ok && module != "" {
+			file = rest
+		}
+		report.Assertions = append(report.Assertions, ReportEntry{
+			ID:        metadata.ID,
+			Location:  file + ":" + strconv.Itoa(line),
+			Kind:      metadata.Kind,
+			Message:   metadata.Message,
+			Frequency: int(metadata.Frequency.Load()),
+		})
+	}
+	assertionFrequencyMutex.Unlock()
+	sortReportEntries(report.Assertions)
+	return report
+}
+
+// sampleTelemetry returns the number of evaluations that the current one stands for, which is zero
+// if it isn't sampled. The random source is per thread so sampling doesn't contend.
+func sampleTelemetry() int64 {
+	every := telemetrySampleEvery.Load()
+	if every <= 1 || rand.Int64N(every) == 0 {
+		return every
+	}
+	return 0
+}
+
+// telemetryAssertion creates the tracker entry of a call site that isn't in a generated table.
+// Unless kind is given, the assertion function is skip frames up the stack of the caller of
+// resolveAssertion. It must be called while holding assertionFrequencyMutex.
+func telemetryAssertion(location string, kind string, skip int) *metadata {
+	a := &metadata{}
+	callers := [1]uintptr{}
+	if kind != "" {
+		a.Kind = kind
+	} else if runtime.Callers(skip+1, callers[:]) > 0 {
+		frame, _ := runtime.CallersFrames(callers[:]).Next()
+		a.Kind = assertionKind(strings.TrimPrefix(frame.Function, invariantFunctionPrefix))
+	}
+	assertionTracker[location] = a
+	return a
+}
@@ -0,0 +1,29 @@
+//go:build disable_assertions
+
+package invariant
+
+type Property struct{}
+
+func AlwaysBefore(a, b string, msg string) Property {
+	return Property{}
+}
+
+func NeverAfter(a, b string, msg string) Property {
+	return Property{}
+}
+
+func EventuallyAfter(b, a string, steps int, msg string) Property {
+	return Property{}
+}
+
+type Timeline struct{}
+
+func NewTimeline(properties ...Property) *Timeline {
+	return &Timeline{}
+}
+
+func (tl *Timeline) Emit(event string) {
+}
+
+func (tl *Timeline) Finish() {
+}
@@ -0,0 +1,186 @@
+//go:build !disable_assertions
+
+package invariant
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	// timelineTraceLength is the number of recent events printed when a property fails.
+	timelineTraceLength = 16
+)
+
+const (
+	propertyBefore uint8 = iota + 1
+	propertyNeverAfter
+	propertyEventuallyAfter
+)
+
+// Property is a temporal property over the events of a Timeline. Properties are tracked by the
+// analyzer at the line where they are declared. They count as true whenever an event satisfies
+// them, so a property whose events never happen is reported as missed.
+//
+// The tracker entry is resolved when the property is first satisfied rather than when it is
+// declared, since package-level properties are declared before the tracker is registered.
+type Property struct {
+	kind  uint8
+	a, b  string
+	steps int
+	msg   string
+	// pc is the call site of the constructor.
+	pc uintptr
+}
+
+// hit counts an event that satisfies p.
+func (p Property) hit() {
+	kind := [...]string{
+		propertyBefore:          "AlwaysBefore",
+		propertyNeverAfter:      "NeverAfter",
+		propertyEventuallyAfter: "EventuallyAfter",
+	}[p.kind]
+	assertionAt(p.pc, kind, 0).hit()
+}
+
+// AlwaysBefore requires that every b is preceded by an a at some point earlier in the timeline.
+//
+//	invariant.AlwaysBefore("Open", "Read", "Read happens after Open")
+//
+//go:noinline
+func AlwaysBefore(a, b string, msg string) Property {
+	return Property{kind: propertyBefore, a: a, b: b, msg: msg, pc: callerPC(3)}
+}
+
+// NeverAfter requires that a never happens once b has happened.
+//
+//	invariant.NeverAfter("Write", "Close", "Write never happens after Close")
+//	invariant.NeverAfter("Close", "Close", "Close is never called twice")
+//
+//go:noinline
+func NeverAfter(a, b string, msg string) Property {
+	return Property{kind: propertyNeverAfter, a: a, b: b, msg: msg, pc: callerPC(3)}
+}
+
+// EventuallyAfter requires that b happens within the next steps events after a. Obligations that
+// are still pending when the timeline finishes also fail.
+//
+//	invariant.EventuallyAfter("Done", "Begin", 3, "Begin is followed by Done")
+//
+//go:noinline
+func EventuallyAfter(b, a string, steps int, msg string) Property {
+	Always(steps > 0, "EventuallyAfter allows at least one step")
+	return Property{kind: propertyEventuallyAfter, a: a, b: b, steps: steps, msg: msg, pc: callerPC(3)}
+}
+
+// Timeline checks properties incrementally as named events are emitted. Use one timeline per
+// object whose lifecycle is being checked. It is safe for concurrent use, in which case the order
+// of events is the order in which Emit acquires the timeline.
+type Timeline struct {
+	mutex      sync.Mutex
+	properties []Property
+	// pending is the number of events left for each EventuallyAfter property to be satisfied.
+	// Zero means nothing is pending.
+	pending []int
+	seen    map[string]bool
+	recent  [timelineTraceLength]string
+	count   int
+}
+
+func NewTimeline(properties ...Property) *Timeline {
+	return &Timeline{
+		properties: properties,
+		pending:    make([]int, len(properties)),
+		seen:       make(map[string]bool),
+	}
+}
+
+// Emit records event and calls assertionFailureCallback if it violates a property.
+//
+//go:noinline
+func (tl *Timeline) Emit(event string) {
+	tl.mutex.Lock()
+	tl.recent[tl.count%timelineTraceLength] = event
+	tl.count++
+	var satisfied []Property
+	failure := ""
+	for i, p := range tl.properties {
+		switch p.kind {
+		case propertyBefore:
+			if event != p.b {
+				continue
+			}
+			if !tl.seen[p.a] {
+				failure = fmt.Sprintf("%q happened before %q. %s", p.b, p.a, p.msg)
+			} else {
+				satisfied = append(satisfied, p)
+			}
+		case propertyNeverAfter:
+			if event != p.a {
+				continue
+			}
+			if tl.seen[p.b] {
+				failure = fmt.Sprintf("%q happened after %q. %s", p.a, p.b, p.msg)
+			} else {
+				satisfied = append(satisfied, p)
+			}
+		case propertyEventuallyAfter:
+			if tl.pending[i] > 0 {
+				if event == p.b {
+					tl.pending[i] = 0
+					satisfied = append(satisfied, p)
+				} else if tl.pending[i]--; tl.pending[i] == 0 {
+					failure = fmt.Sprintf("%q didn't happen within %d events after %q. %s", p.b, p.steps, p.a, p.msg)
+				}
+			}
+			if event == p.a && tl.pending[i] == 0 && failure == "" {
+				tl.pending[i] = p.steps
+			}
+		}
+		if failure != "" {
+			break
+		}
+	}
+	tl.seen[event] = true
+	if failure != "" {
+		failure = fmt.Sprintf("%s\nrecent events: %s", failure, tl.trace())
+	}
+	tl.mutex.Unlock()
+
+	for _, p := range satisfied {
+		p.hit()
+	}
+	if failure != "" {
+		assertionFailureCallback(failure)
+	}
+}
+
+// Finish calls assertionFailureCallback if any EventuallyAfter obligation is still pending.
+//
+//go:noinline
+func (tl *Timeline) Finish() {
+	tl.mutex.Lock()
+	failure := ""
+	for i, p := range tl.properties {
+		if p.kind == propertyEventuallyAfter && tl.pending[i] > 0 {
+			failure = fmt.Sprintf("the timeline finished before %q happened after %q. %s\nrecent events: %s", p.b, p.a, p.msg, tl.trace())
+			break
+		}
+	}
+	tl.mutex.Unlock()
+
+	if failure != "" {
+		assertionFailureCallback(failure)
+	}
+}
+
+// trace must be called while holding the mutex.
+func (tl *Timeline) trace() string {
+	start := max(0, tl.count-timelineTraceLength)
+	events := make([]string, 0, tl.count-start)
+	for i := start; i < tl.count; i++ {
+		events = append(events, tl.recent[i%timelineTraceLength])
+	}
+	return strings.Join(events, " -> ")
+}
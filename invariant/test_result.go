@@ -0,0 +1,308 @@
+package invariant
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReportFormat is the output format of RunTests.
+type ReportFormat string
+
+const (
+	// FormatText lists the missed assertions, the violated thresholds and the least exercised
+	// assertions for humans.
+	FormatText ReportFormat = "text"
+	// FormatJSON writes the TestResult.
+	FormatJSON ReportFormat = "json"
+	// FormatJUnit writes a JUnit XML test suite with a test case per assertion so that CI
+	// systems show missed assertions next to failed tests.
+	FormatJUnit ReportFormat = "junit"
+
+	defaultLeastExercised = 10
+)
+
+// TestOptions configures RunTests. The zero value behaves like RunTestMain(m).
+//
+//	func TestMain(m *testing.M) {
+//		result := invariant.RunTests(m, invariant.TestOptions{
+//			Dirs:         []string{"./..."},
+//			MinFrequency: map[string]int{"Sometimes": 3},
+//			KnownMisses: []invariant.KnownMiss{{
+//				Message: "Replica falls behind",
+//				Expires: time.Date(2026, time.December, 1, 0, 0, 0, 0, time.UTC),
+//				Reason:  "Needs the partition simulation of #42",
+//			}},
+//		})
+//		cleanup()
+//		os.Exit(result.Code)
+//	}
+type TestOptions struct {
+	// Dirs are registered for analysis. Refer to RegisterPackagesForAnalysis.
+	Dirs []string
+	// Writer receives the output in Format. Defaults to os.Stdout.
+	Writer io.Writer
+	// Format defaults to FormatText.
+	Format ReportFormat
+	// MinFrequency is the number of times that assertions of each Kind must be true, such as
+	// "Sometimes". Assertions that were true fewer times violate their threshold, like a
+	// SometimesCount.
+	MinFrequency map[string]int
+	// KnownMisses are missed assertions that don't fail the run until they expire.
+	KnownMisses []KnownMiss
+	// LeastExercised is the number of the least exercised assertions listed by FormatText.
+	// Defaults to 10. Negative disables the list.
+	LeastExercised int
+}
+
+// KnownMiss allows an assertion to be missed until Expires so that a known gap in the tests
+// doesn't fail every run while it's being worked on. The assertion is matched by its ID if set.
+// Otherwise, it is matched by its Message and, if set, the end of its Location such as
+// "service/queue.go:42".
+type KnownMiss struct {
+	ID       uint64
+	Message  string
+	Location string
+	// Expires is when the miss fails the run again. Zero never expires.
+	Expires time.Time
+	Reason  string
+}
+
+// Matches reports whether entry is the allowed assertion.
+func (known KnownMiss) Matches(entry ReportEntry) bool {
+	if known.ID != 0 {
+		return known.ID == entry.ID
+	}
+	return known.Message == entry.Message && strings.HasSuffix(entry.Location, known.Location)
+}
+
+func (known KnownMiss) isExpired(now time.Time) bool {
+	return !known.Expires.IsZero() && !now.Before(known.Expires)
+}
+
+// outlives reports whether known expires after other.
+func (known KnownMiss) outlives(other KnownMiss) bool {
+	if other.Expires.IsZero() {
+		return false
+	}
+	return known.Expires.IsZero() || known.Expires.After(other.Expires)
+}
+
+func (known KnownMiss) equal(other KnownMiss) bool {
+	return known.ID == other.ID && known.Message == other.Message && known.Location == other.Location &&
+		known.Expires.Equal(other.Expires) && known.Reason == other.Reason
+}
+
+// applyTo stores the MinFrequency and KnownMisses of options in the entries of report, so that
+// the reports written under ReportDirEnv carry them to `invariant check`.
+func (options TestOptions) applyTo(report Report) Report {
+	assertions := make([]ReportEntry, len(report.Assertions))
+	copy(assertions, report.Assertions)
+	report.Assertions = assertions
+	for i := range report.Assertions {
+		entry := &report.Assertions[i]
+		if entry.Kind != "ProbablyRatio" {
+			entry.MinFrequency = max(entry.MinFrequency, options.MinFrequency[entry.Kind])
+		}
+		for _, known := range options.KnownMisses {
+			if known.Matches(*entry) && (entry.KnownMiss == nil || known.outlives(*entry.KnownMiss)) {
+				entry.KnownMiss = &known
+			}
+		}
+	}
+	return report
+}
+
+// TestResult is the outcome of RunTests.
+type TestResult struct {
+	// Code is the exit code for os.Exit. It is the code of the tests, or 1 if they passed but
+	// assertions were missed or violated their thresholds. Fuzzing and benchmarking don't fail
+	// on assertions since they only exercise part of the package.
+	Code int
+	// Report has every analyzed assertion with the thresholds of MinFrequency applied.
+	Report Report
+	// Missed were never true and aren't allowed by an unexpired KnownMiss.
+	Missed     []ReportEntry
+	Violations []ReportEntry
+	// Allowed were never true but are allowed by an unexpired KnownMiss.
+	Allowed []ReportEntry
+	// Expired are the KnownMisses that expired while their assertion is still missed. Their
+	// assertions are in Missed.
+	Expired []KnownMiss
+	// LeastExercised are the covered assertions with the lowest frequencies, least first.
+	LeastExercised []ReportEntry
+}
+
+// Failed reports whether any assertion was missed or violated its threshold.
+func (result TestResult) Failed() bool {
+	return len(result.Missed) > 0 || len(result.Violations) > 0
+}
+
+// AnalyzeReport applies options to report. It is the analysis of RunTests without running tests,
+// which leaves Code zero. The thresholds and known misses already stored in the report, such as
+// the ones of reports written by RunTests, are applied as well.
+func AnalyzeReport(report Report, options TestOptions, now time.Time) TestResult {
+	report = options.applyTo(report)
+	result := TestResult{Report: report, Violations: report.Violations()}
+	for _, entry := range report.Missed() {
+		allowed := false
+		if known := entry.KnownMiss; known != nil {
+			allowed = !known.isExpired(now)
+			if !allowed && !slices.ContainsFunc(result.Expired, known.equal) {
+				result.Expired = append(result.Expired, *known)
+			}
+		}
+		if allowed {
+			result.Allowed = append(result.Allowed, entry)
+		} else {
+			result.Missed = append(result.Missed, entry)
+		}
+	}
+
+	leastExercised := options.LeastExercised
+	if leastExercised == 0 {
+		leastExercised = defaultLeastExercised
+	}
+	for _, entry := range report.Assertions {
+		if !entry.IsMissed() {
+			result.LeastExercised = append(result.LeastExercised, entry)
+		}
+	}
+	sort.SliceStable(result.LeastExercised, func(i, j int) bool {
+		return result.LeastExercised[i].Frequency < result.LeastExercised[j].Frequency
+	})
+	result.LeastExercised = result.LeastExercised[:max(0, min(leastExercised, len(result.LeastExercised)))]
+	return result
+}
+
+// WriteTestResult writes result to w in format.
+func WriteTestResult(w io.Writer, result TestResult, format ReportFormat) error {
+	switch format {
+	case FormatText, "":
+		return writeTestResultText(w, result)
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "\t")
+		return encoder.Encode(result)
+	case FormatJUnit:
+		return writeTestResultJUnit(w, result)
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+func writeTestResultText(w io.Writer, result TestResult) error {
+	if len(result.Missed) > 0 {
+		FprintMissed(w, result.Missed)
+	}
+	if len(result.Violations) > 0 {
+		FprintViolations(w, result.Violations)
+	}
+	for _, known := range result.Expired {
+		fmt.Fprintf(w, "🚨 The known miss of %q expired on %s. 🚨\n", known.Message, known.Expires.Format(time.DateOnly))
+	}
+	if len(result.Allowed) > 0 {
+		fmt.Fprintf(w, "%d known missed assertions were allowed.\n", len(result.Allowed))
+	}
+	if len(result.LeastExercised) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "The %d least-exercised invariants:\n", len(result.LeastExercised))
+	longestMessageLength := 0
+	longestKindWord := 0
+	for _, entry := range result.LeastExercised {
+		longestMessageLength = max(longestMessageLength, len(entry.Message))
+		longestKindWord = max(longestKindWord, len(entry.Kind))
+	}
+	for _, entry := range result.LeastExercised {
+		_, err := fmt.Fprintf(
+			w,
+			"count=%-4d | %-*s | %-*s | %s\n",
+			entry.Frequency,
+			longestKindWord, entry.Kind,
+			longestMessageLength, entry.Message,
+			entry.Location,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+func writeTestResultJUnit(w io.Writer, result TestResult) error {
+	missed := make(map[string]bool, len(result.Missed))
+	for _, entry := range result.Missed {
+		missed[reportEntryKey(entry)] = true
+	}
+	allowed := make(map[string]bool, len(result.Allowed))
+	for _, entry := range result.Allowed {
+		allowed[reportEntryKey(entry)] = true
+	}
+	suite := junitTestSuite{Name: result.Report.Module, Tests: len(result.Report.Assertions)}
+	if suite.Name == "" {
+		suite.Name = "invariant"
+	}
+	for _, entry := range result.Report.Assertions {
+		testCase := junitTestCase{Name: entry.Kind + ": " + entry.Message, ClassName: entry.Location}
+		switch key := reportEntryKey(entry); {
+		case missed[key]:
+			testCase.Failure = &junitMessage{Message: "never true"}
+		case allowed[key]:
+			testCase.Skipped = &junitMessage{Message: "known miss"}
+		case entry.Violation() != "":
+			testCase.Failure = &junitMessage{Message: entry.Violation()}
+		}
+		if testCase.Failure != nil {
+			suite.Failures++
+		}
+		if testCase.Skipped != nil {
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "\t")
+	if err := encoder.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// testResultWriter defaults the Writer of options to os.Stdout.
+func testResultWriter(options TestOptions) io.Writer {
+	if options.Writer == nil {
+		return os.Stdout
+	}
+	return options.Writer
+}
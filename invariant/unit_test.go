@@ -0,0 +1,870 @@
+//go:build !disable_assertions
+
+package invariant_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+	"github.com/james-orcales/golang_snacks/myers"
+	"github.com/james-orcales/golang_snacks/snap"
+)
+
+func check(t *testing.T, actual string, snapshot snap.Snapshot) {
+	t.Helper()
+	if !snapshot.IsEqual(actual) {
+		t.Fatal("Snapshot mismatch")
+	}
+}
+
+func TestMergeReports(t *testing.T) {
+	const module = "example.com/foo"
+	a := invariant.Report{Version: invariant.ReportVersion, Module: module, Assertions: []invariant.ReportEntry{
+		{Location: "foo/foo.go:10", Kind: "Sometimes", Message: "covered by b", Frequency: 0},
+		{Location: "foo/foo.go:2", Kind: "Always", Message: "covered by both", Frequency: 3},
+		{Location: "bar/bar.go:7", Kind: "Sometimes", Message: "never covered", Frequency: 0},
+	}}
+	b := invariant.Report{Version: invariant.ReportVersion, Module: module, Assertions: []invariant.ReportEntry{
+		{Location: "foo/foo.go:10", Kind: "Sometimes", Message: "covered by b", Frequency: 1},
+		{Location: "foo/foo.go:2", Kind: "Always", Message: "covered by both", Frequency: 4},
+		{Location: "baz/baz.go:1", Kind: "Always", Message: "only in b", Frequency: 5},
+	}}
+
+	merged, err := invariant.MergeReports(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	for _, entry := range merged.Assertions {
+		out.WriteString(entry.Location + " " + entry.Message + "\n")
+	}
+	invariant.FprintMissed(out, merged.Missed())
+	if merged.Assertions[2].Frequency != 7 {
+		t.Fatalf("Frequencies are summed. got %d", merged.Assertions[2].Frequency)
+	}
+	check(t, out.String(), snap.Init(`bar/bar.go:7 never covered
+baz/baz.go:1 only in b
+foo/foo.go:2 covered by both
+foo/foo.go:10 covered by b
+🚨 1 assertions were never true. 🚨
+	Sometimes | never covered | bar/bar.go:7
+`))
+
+	b.Module = "example.com/bar"
+	if _, err := invariant.MergeReports(a, b); err == nil {
+		t.Fatal("Merged reports of different modules")
+	}
+}
+
+func TestScanAssertions(t *testing.T) {
+	const src = `package foo
+
+import "github.com/james-orcales/golang_snacks/invariant"
+
+func (f *Foo) Bar(x int) {
+	invariant.Always(x > 0, "x is positive")
+	invariant.Always(x > 0, "x is positive")
+	invariant.Sometimes(x == 1, "x is one")
+	invariant.AlwaysEqual[int](x, 1, "explicit type argument")
+	other.Always(x > 0, "not tracked")
+}
+`
+	scan := func(src string) []invariant.AssertionSite {
+		t.Helper()
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "foo.go", src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sites, err := invariant.ScanAssertions(fset, file, "foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sites
+	}
+	before := scan(src)
+	after := scan(strings.Replace(src, "{\n", "{\n\n\n", 1))
+	out := &bytes.Buffer{}
+	for i, site := range before {
+		fmt.Fprintf(out, "%s:%d %s %q\n", site.File, site.Line, site.Kind, site.Message)
+		if site.ID != after[i].ID || site.Line == after[i].Line {
+			t.Fatalf("IDs survive line shifts. got %x and %x", site.ID, after[i].ID)
+		}
+	}
+	if before[0].ID == before[1].ID {
+		t.Fatal("Identical assertions in the same function have distinct IDs")
+	}
+	check(t, out.String(), snap.Init(`foo.go:6 Always "x is positive"
+foo.go:7 Always "x is positive"
+foo.go:8 Sometimes "x is one"
+foo.go:9 AlwaysEqual "explicit type argument"
+`))
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "foo.go", strings.Replace(src, `"x is one"`, "msg", 1), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invariant.ScanAssertions(fset, file, "foo"); err == nil {
+		t.Fatal("Non-literal messages are rejected")
+	}
+}
+
+func TestAttribution(t *testing.T) {
+	const module = "example.com/foo"
+	a := invariant.Report{Version: invariant.ReportVersion, Module: module, Assertions: []invariant.ReportEntry{
+		{Location: "foo/foo.go:1", Kind: "Sometimes", Message: "only TestA", Frequency: 1, Tests: []string{"TestA"}},
+		{Location: "foo/foo.go:2", Kind: "Always", Message: "both", Frequency: 1, Tests: []string{"TestA"}},
+		{Location: "foo/foo.go:3", Kind: "Always", Message: "unattributed", Frequency: 1},
+	}}
+	b := invariant.Report{Version: invariant.ReportVersion, Module: module, Assertions: []invariant.ReportEntry{
+		{Location: "foo/foo.go:2", Kind: "Always", Message: "both", Frequency: 2, Tests: []string{"TestB/sub", "TestA"}},
+	}}
+
+	merged, err := invariant.MergeReports(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	invariant.FprintAttribution(out, merged)
+	check(t, out.String(), snap.Init(`Tests that exercised each assertion:
+	foo/foo.go:1 | Sometimes | only TestA
+		TestA
+	foo/foo.go:2 | Always | both
+		TestA
+		TestB/sub
+	foo/foo.go:3 | Always | unattributed
+		(no attributed tests)
+Assertions that only one test exercised:
+	TestA
+		foo/foo.go:1 | Sometimes | only TestA
+`))
+}
+
+// failure returns the message of the assertion failure caused by assert.
+func failure(t *testing.T, assert func()) string {
+	t.Helper()
+	var failure *invariant.AssertionFailure
+	if !errors.As(invariant.Catch(assert), &failure) {
+		t.Fatal("Assertion didn't fail")
+	}
+	return failure.Message + "\n"
+}
+
+func TestComparisons(t *testing.T) {
+	invariant.AssertionDiffHook = myers.AssertionDiff
+	t.Cleanup(func() { invariant.AssertionDiffHook = nil })
+	long := strings.Repeat("a", 40)
+	out := &bytes.Buffer{}
+	for _, msg := range []string{
+		failure(t, func() { invariant.AlwaysEqual(1, 2, "equal") }),
+		failure(t, func() { invariant.AlwaysEqual(long+"b"+long, long+"c"+long, "long strings are diffed") }),
+		failure(t, func() { invariant.AlwaysEqual("foo\nbar\nbaz", "foo\nqux\nbaz", "lines are diffed") }),
+		failure(t, func() { invariant.AlwaysLess(2, 2, "less") }),
+		failure(t, func() { invariant.AlwaysInRange(1.5, 2, 3, "range") }),
+		failure(t, func() { invariant.AlwaysLen(map[string]int{"a": 1}, 2, "len") }),
+		failure(t, func() { invariant.AlwaysLen(1, 2, "no length") }),
+		failure(t, func() { invariant.AlwaysContains([]string{"a", "b"}, "c", "contains") }),
+		failure(t, func() { invariant.AlwaysDeepEqual([]int{1, 2, 3}, []int{1, 3}, "deep equal") }),
+		failure(t, func() {
+			invariant.AlwaysDeepEqual(
+				[]string{long + "1", long + "2", long + "3"},
+				[]string{long + "1", long + "3"},
+				"slices are diffed per element",
+			)
+		}),
+	} {
+		out.WriteString(msg)
+	}
+	check(t, out.String(), snap.Init(`expected 2. got 1. equal
+long strings are diffed. expected (-) vs got (+):
+ "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"-"c"+"b" "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+lines are diffed. expected (-) vs got (+):
+ foo
+-qux
++bar
+ baz
+expected 2 < 2. less
+expected 1.5 in [2, 3]. range
+expected length 2. got 1: map[string]int{"a":1}. len
+invariant.AlwaysLen requires a value with a length. got int. no length
+expected "c" in []string{"a", "b"}. contains
+expected []int{1, 3}. got []int{1, 2, 3}. deep equal
+slices are diffed per element. expected (-) vs got (+):
+ "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa1"
++"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa2"
+ "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa3"
+`))
+
+	invariant.AlwaysEqual(errors.ErrUnsupported, errors.ErrUnsupported, "equal")
+	invariant.AlwaysLess("a", "b", "less")
+	invariant.AlwaysInRange(3, 1, 3, "range is inclusive")
+	invariant.AlwaysLen("abc", 3, "len")
+	invariant.AlwaysContains([]int{1, 2}, 2, "contains")
+	invariant.AlwaysDeepEqual(map[string][]int{"a": {1}}, map[string][]int{"a": {1}}, "deep equal")
+}
+
+func TestThresholds(t *testing.T) {
+	const module = "example.com/foo"
+	a := invariant.Report{Version: invariant.ReportVersion, Module: module, Assertions: []invariant.ReportEntry{
+		{Location: "foo/foo.go:1", Kind: "SometimesCount", Message: "met across reports", Frequency: 2, MinFrequency: 3},
+		{Location: "foo/foo.go:2", Kind: "SometimesCount", Message: "below minimum", Frequency: 1, MinFrequency: 3},
+		{Location: "foo/foo.go:3", Kind: "ProbablyRatio", Message: "within band", Frequency: 1, Evaluations: 10, RatioLo: 0.05, RatioHi: 0.2},
+		{Location: "foo/foo.go:4", Kind: "ProbablyRatio", Message: "skewed", Frequency: 9, Evaluations: 10, RatioLo: 0.05, RatioHi: 0.2},
+		{Location: "foo/foo.go:5", Kind: "ProbablyRatio", Message: "never true but allowed", Evaluations: 10, RatioHi: 0.1},
+		{Location: "foo/foo.go:6", Kind: "ProbablyRatio", Message: "never evaluated"},
+	}}
+	b := invariant.Report{Version: invariant.ReportVersion, Module: module, Assertions: []invariant.ReportEntry{
+		{Location: "foo/foo.go:1", Kind: "SometimesCount", Message: "met across reports", Frequency: 1, MinFrequency: 3},
+	}}
+
+	merged, err := invariant.MergeReports(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	invariant.FprintMissed(out, merged.Missed())
+	invariant.FprintViolations(out, merged.Violations())
+	check(t, out.String(), snap.Init(`🚨 1 assertions were never true. 🚨
+	ProbablyRatio | never evaluated | foo/foo.go:6
+🚨 2 assertions violated their thresholds. 🚨
+	SometimesCount | below minimum | foo/foo.go:2 | true 1 times, expected at least 3
+	 ProbablyRatio | skewed        | foo/foo.go:4 | true in 0.900 of 10 evaluations, expected [0.05, 0.2]
+`))
+}
+
+func TestProbablyRatioBounds(t *testing.T) {
+	out := &bytes.Buffer{}
+	for _, msg := range []string{
+		failure(t, func() { invariant.ProbablyRatio(true, 0.5, 0.1, "inverted") }),
+		failure(t, func() { invariant.ProbablyRatio(true, -0.1, 0.5, "negative") }),
+		failure(t, func() { invariant.ProbablyRatio(true, 0.5, 1.5, "above one") }),
+	} {
+		out.WriteString(msg)
+	}
+	check(t, out.String(), snap.Init(`expected 0 <= lo <= hi <= 1. got [0.5, 0.1]. inverted
+expected 0 <= lo <= hi <= 1. got [-0.1, 0.5]. negative
+expected 0 <= lo <= hi <= 1. got [0.5, 1.5]. above one
+`))
+}
+
+func TestTimeline(t *testing.T) {
+	properties := func() []invariant.Property {
+		return []invariant.Property{
+			invariant.AlwaysBefore("Open", "Read", "Read happens after Open"),
+			invariant.NeverAfter("Close", "Close", "Close is never called twice"),
+			invariant.EventuallyAfter("Done", "Begin", 2, "Begin is followed by Done"),
+		}
+	}
+	emit := func(tl *invariant.Timeline, events ...string) {
+		for _, event := range events {
+			tl.Emit(event)
+		}
+	}
+
+	tl := invariant.NewTimeline(properties()...)
+	emit(tl, "Open", "Read", "Begin", "Read", "Done", "Begin", "Done", "Close")
+	tl.Finish()
+
+	out := &bytes.Buffer{}
+	for _, msg := range []string{
+		failure(t, func() { emit(invariant.NewTimeline(properties()...), "Read") }),
+		failure(t, func() { emit(invariant.NewTimeline(properties()...), "Open", "Close", "Close") }),
+		failure(t, func() { emit(invariant.NewTimeline(properties()...), "Open", "Begin", "Read", "Read") }),
+		failure(t, func() {
+			tl := invariant.NewTimeline(properties()...)
+			emit(tl, "Begin")
+			tl.Finish()
+		}),
+	} {
+		out.WriteString(msg)
+	}
+	check(t, out.String(), snap.Init(`"Read" happened before "Open". Read happens after Open
+recent events: Read
+"Close" happened after "Close". Close is never called twice
+recent events: Open -> Close -> Close
+"Done" didn't happen within 2 events after "Begin". Begin is followed by Done
+recent events: Open -> Begin -> Read -> Read
+the timeline finished before "Done" happened after "Begin". Begin is followed by Done
+recent events: Begin
+`))
+}
+
+func TestAssertionFailure(t *testing.T) {
+	out := &bytes.Buffer{}
+	for _, assert := range []func(){
+		func() { invariant.Always(false, "always") },
+		func() { invariant.AlwaysErrIs(nil, []error{nil}, "nil target") },
+		func() { invariant.AlwaysEqual(1, 2, "generic") },
+		func() { invariant.Ensure(false, "ensure") },
+		func() {
+			invariant.NewTimeline(invariant.NeverAfter("A", "A", "method")).Emit("A")
+			panic("unreachable")
+		},
+		func() {
+			tl := invariant.NewTimeline(invariant.NeverAfter("A", "A", "method"))
+			tl.Emit("A")
+			tl.Emit("A")
+		},
+		func() {
+			for range invariant.Until(1) {
+			}
+		},
+	} {
+		err := func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("%v", r)
+				}
+			}()
+			return invariant.Catch(assert)
+		}()
+		var failure *invariant.AssertionFailure
+		if !errors.As(err, &failure) {
+			fmt.Fprintf(out, "propagated: %v\n", err)
+			continue
+		}
+		file, line, _ := strings.Cut(filepath.Base(failure.Location), ":")
+		if line == "" {
+			t.Fatalf("The location has a line. got %s", failure.Location)
+		}
+		fmt.Fprintf(out, "%s | %s | %s | %q\n", failure.Kind, failure.Message, file, failure.Error())
+		if !strings.Contains(failure.Stack, failure.Location) {
+			t.Fatalf("The stack starts at the assertion call. got\n%s", failure.Stack)
+		}
+	}
+	check(t, out.String(), snap.Init(`Always | always | unit_test.go | "🚨 Assertion Failure 🚨: always"
+AlwaysErrIs | All invariant.AlwaysErrIs targets must not be nil | unit_test.go | "🚨 Assertion Failure 🚨: All invariant.AlwaysErrIs targets must not be nil"
+AlwaysEqual | expected 2. got 1. generic | unit_test.go | "🚨 Assertion Failure 🚨: expected 2. got 1. generic"
+Ensure | ensure | unit_test.go | "🚨 Assertion Failure 🚨: ensure"
+propagated: unreachable
+Timeline.Emit | "A" happened after "A". method
+recent events: A -> A | unit_test.go | "🚨 Assertion Failure 🚨: \"A\" happened after \"A\". method\nrecent events: A -> A"
+Until | Runaway loop! | unit_test.go | "🚨 Assertion Failure 🚨: Runaway loop!"
+`))
+}
+
+func TestFailurePolicy(t *testing.T) {
+	policy := invariant.FailurePolicy{
+		Mode: invariant.FailurePanic,
+		Rules: []invariant.FailureRule{
+			{Package: "example.com/service/...", Mode: invariant.FailureLog},
+			{Package: "example.com/service/cache", Kind: "XAlways*", Mode: invariant.FailureCount},
+			{Kind: "Ensure", Mode: invariant.FailureExit},
+		},
+	}
+	out := &bytes.Buffer{}
+	for _, c := range []struct{ pkg, kind string }{
+		{"example.com/other", "Always"},
+		{"example.com/service", "Always"},
+		{"example.com/servicefoo", "Always"},
+		{"example.com/service/cache", "XAlwaysNil"},
+		{"example.com/service/cache", "Always"},
+		{"example.com/service/cache", "Ensure"},
+	} {
+		fmt.Fprintf(out, "%s %s: %s\n", c.pkg, c.kind, policy.ModeFor(c.pkg, c.kind))
+	}
+
+	invariant.SetFailurePolicy(invariant.FailurePolicy{
+		Rules: []invariant.FailureRule{
+			{Package: "github.com/james-orcales/golang_snacks/invariant_test", Kind: "Always*", Mode: invariant.FailureCount},
+			{Package: "github.com/james-orcales/golang_snacks/invariant_test", Kind: "Unreachable", Mode: invariant.FailureLog},
+		},
+		SummaryWriter: out,
+	})
+	defer invariant.SetFailurePolicy(invariant.FailurePolicy{})
+	for range 3 {
+		invariant.Always(false, "counted") //invariant:loop
+	}
+	invariant.AlwaysEqual(1, 2, "counted")
+	invariant.Unreachable("logged")
+	invariant.FlushFailureSummary()
+	invariant.FlushFailureSummary()
+	if err := invariant.Catch(func() { invariant.Ensure(false, "still panics") }); err == nil {
+		t.Fatal("The default mode panics")
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, strings.ReplaceAll(out.String(), wd, "invariant"), snap.Init(`example.com/other Always: panic
+example.com/service Always: log
+example.com/servicefoo Always: panic
+example.com/service/cache XAlwaysNil: count
+example.com/service/cache Always: log
+example.com/service/cache Ensure: exit
+🚨 Assertion Failure 🚨: logged | invariant/unit_test.go:391
+🚨 4 assertion failures since the last summary. 🚨
+	count=3    |      Always | counted                    | invariant/unit_test.go:388
+	count=1    | AlwaysEqual | expected 2. got 1. counted | invariant/unit_test.go:390
+`))
+}
+
+func TestPackagePatterns(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod":                  "module example.com/foo\n",
+		"foo/foo.go":              "package foo\n",
+		"foo/foo_test.go":         "package foo\n",
+		"foo/foo_never.go":        "//go:build never\n\npackage foo\n",
+		"foo/foo_tagged.go":       "//go:build tagged\n\npackage foo\n",
+		"foo/bar/bar.go":          "package bar\n",
+		"foo/testdata/data.go":    "package data\n",
+		"foo/vendor/dep/dep.go":   "package dep\n",
+		"foo/.hidden/hidden.go":   "package hidden\n",
+		"foo/_ignored/ignored.go": "package ignored\n",
+		"foo/nested/go.mod":       "module example.com/nested\n",
+		"foo/nested/nested.go":    "package nested\n",
+		"foo/bar/baz/README":      "",
+	} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(root)
+	relative := func(paths []string) string {
+		out := &strings.Builder{}
+		for _, path := range paths {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintln(out, filepath.ToSlash(rel))
+		}
+		return out.String()
+	}
+
+	out := &strings.Builder{}
+	for _, pattern := range []string{"./foo/...", "example.com/foo/foo/...", "./foo"} {
+		dirs, err := invariant.ExpandPackagePattern(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(out, "%s:\n%s", pattern, relative(dirs))
+	}
+	context := build.Default
+	for _, tags := range [][]string{nil, {"tagged"}} {
+		context.BuildTags = tags
+		files, err := invariant.PackageFiles(context, filepath.Join(root, "foo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(out, "files with tags %v:\n%s", tags, relative(files))
+	}
+	check(t, out.String(), snap.Init(`./foo/...:
+foo
+foo/bar
+foo/bar/baz
+example.com/foo/foo/...:
+foo
+foo/bar
+foo/bar/baz
+./foo:
+foo
+files with tags []:
+foo/foo.go
+files with tags [tagged]:
+foo/foo.go
+foo/foo_tagged.go
+`))
+}
+
+func TestDiffReports(t *testing.T) {
+	const module = "example.com/foo"
+	old := invariant.Report{Version: invariant.ReportVersion, Module: module, Assertions: []invariant.ReportEntry{
+		{ID: 1, Location: "foo/foo.go:10", Kind: "Sometimes", Message: "lost", Frequency: 12},
+		{ID: 2, Location: "foo/foo.go:20", Kind: "Always", Message: "dropped", Frequency: 100},
+		{ID: 3, Location: "foo/foo.go:30", Kind: "Always", Message: "stable", Frequency: 100},
+		{ID: 4, Location: "foo/foo.go:40", Kind: "Reachable", Message: "removed", Frequency: 3},
+		{ID: 6, Location: "foo/foo.go:60", Kind: "Sometimes", Message: "still missed", Frequency: 0},
+	}}
+	new := invariant.Report{Version: invariant.ReportVersion, Module: module, Assertions: []invariant.ReportEntry{
+		{ID: 1, Location: "foo/foo.go:12", Kind: "Sometimes", Message: "lost", Frequency: 0},
+		{ID: 2, Location: "foo/foo.go:22", Kind: "Always", Message: "dropped", Frequency: 10},
+		{ID: 3, Location: "foo/foo.go:32", Kind: "Always", Message: "stable", Frequency: 60},
+		{ID: 5, Location: "foo/foo.go:50", Kind: "Always", Message: "added", Frequency: 1},
+		{ID: 6, Location: "foo/foo.go:62", Kind: "Sometimes", Message: "still missed", Frequency: 0},
+	}}
+
+	diff, err := invariant.DiffReports(old, new, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	for _, pair := range diff.NewlyMissed {
+		fmt.Fprintf(out, "newly missed %s -> %s\n", pair[0].Location, pair[1].Location)
+	}
+	for _, pair := range diff.Dropped {
+		fmt.Fprintf(out, "dropped %d -> %d\n", pair[0].Frequency, pair[1].Frequency)
+	}
+	for _, entry := range diff.Added {
+		fmt.Fprintf(out, "added %s\n", entry.Message)
+	}
+	for _, entry := range diff.Removed {
+		fmt.Fprintf(out, "removed %s\n", entry.Message)
+	}
+	check(t, out.String(), snap.Init(`newly missed foo/foo.go:10 -> foo/foo.go:12
+dropped 100 -> 10
+added added
+removed removed
+`))
+	if !diff.IsRegression() {
+		t.Fatal("Losing coverage is a regression")
+	}
+
+	if diff, _ := invariant.DiffReports(old, old, 0); diff.IsRegression() || len(diff.Added)+len(diff.Removed) > 0 {
+		t.Fatal("A report doesn't differ from itself")
+	}
+	new.Module = "example.com/bar"
+	if _, err := invariant.DiffReports(old, new, 0.5); err == nil {
+		t.Fatal("Diffed reports of different modules")
+	}
+}
+
+// evaluate is a single call site that is evaluated n times.
+func evaluate(n int) {
+	for range n {
+		invariant.Always(true, "sampled") //invariant:loop
+	}
+}
+
+func TestTelemetry(t *testing.T) {
+	invariant.IsRunningUnderGoTest = false
+	defer func() { invariant.IsRunningUnderGoTest = true }()
+	path := filepath.Join(t.TempDir(), "telemetry.json")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	invariant.SetTelemetry(invariant.Telemetry{SampleEvery: 1, Writer: file})
+	defer invariant.SetTelemetry(invariant.Telemetry{})
+
+	evaluate(3)
+	invariant.Sometimes(false, "never true")
+	invariant.AlwaysEqual(1, 1, "equal")
+	if err := invariant.FlushTelemetry(); err != nil {
+		t.Fatal(err)
+	}
+	report, err := invariant.ReadReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := &strings.Builder{}
+	for _, entry := range report.Assertions {
+		if strings.Contains(entry.Location, "unit_test.go") {
+			fmt.Fprintf(snapshot, "%s %s %d\n", strings.ReplaceAll(entry.Location, wd, "invariant"), entry.Kind, entry.Frequency)
+		}
+	}
+	check(t, snapshot.String(), snap.Init(`invariant/unit_test.go:543 Always 3
+invariant/unit_test.go:561 AlwaysEqual 1
+`))
+
+	// Sampled evaluations are counted SampleEvery times.
+	invariant.SetTelemetry(invariant.Telemetry{SampleEvery: 4})
+	evaluate(4000)
+	for _, entry := range invariant.TelemetrySnapshot().Assertions {
+		if entry.Kind == "Always" && strings.Contains(entry.Location, "unit_test.go") {
+			if entry.Frequency%4 != 3 || entry.Frequency < 2000 || entry.Frequency > 6000 {
+				t.Fatalf("Sampled counts estimate the frequency. got %d", entry.Frequency)
+			}
+		}
+	}
+}
+
+func TestBoundedLoops(t *testing.T) {
+	now := 0
+	clock := func() int { return now }
+	out := &bytes.Buffer{}
+	out.WriteString(failure(t, func() {
+		for range invariant.UntilDeadline(10, clock) {
+			now += 3
+		}
+	}))
+	remaining := 10
+	out.WriteString(failure(t, func() {
+		for i := range invariant.UntilProgress(func() int { return remaining }, 3) {
+			// Progress stops halfway, then the work oscillates.
+			if remaining > 5 {
+				remaining--
+			} else {
+				remaining += i % 2
+			}
+		}
+	}))
+	check(t, out.String(), snap.Init(`Runaway loop! The deadline passed after 4 iterations
+Livelocked loop! The remaining work stopped decreasing at 5 for 3 iterations
+`))
+
+	remaining = 10
+	for range invariant.UntilProgress(func() int { return remaining }, 1) {
+		if remaining == 0 {
+			break
+		}
+		remaining--
+	}
+	now = 0
+	for i := range invariant.UntilDeadline(10, clock) {
+		if i == 2 {
+			break
+		}
+		now++
+	}
+}
+
+type sortedInts []int
+
+func (s *sortedInts) Invariants() error {
+	for i := 1; i < len(*s); i++ {
+		if (*s)[i-1] > (*s)[i] {
+			return fmt.Errorf("%d comes before %d", (*s)[i-1], (*s)[i])
+		}
+	}
+	return nil
+}
+
+func TestStructuralAssertions(t *testing.T) {
+	type node struct {
+		Values []int
+		Next   *node
+		Labels map[string]any
+	}
+	s := &sortedInts{1, 2, 3}
+	invariant.CheckAround(s, func() { *s = append(*s, 4) }, "Appending the maximum keeps it sorted")
+	invariant.XAlwaysValid(s, "Sorted")
+
+	cycle := &node{Values: []int{1}, Labels: map[string]any{"tags": []string{"a"}}}
+	cycle.Next = cycle
+	frozen := invariant.Freeze(&cycle.Labels, cycle, s)
+	invariant.XAlwaysUnchanged(frozen, "Nothing changed")
+
+	out := &bytes.Buffer{}
+	out.WriteString(failure(t, func() {
+		invariant.CheckAround(s, func() { *s = append(*s, 0) }, "Appending the minimum breaks it")
+	}))
+	out.WriteString(failure(t, func() {
+		cycle.Labels["tags"].([]string)[0] = "b"
+		invariant.XAlwaysUnchanged(frozen, "The tags are frozen")
+	}))
+	check(t, out.String(), snap.Init(`*invariant_test.sortedInts is invalid after the call: 4 comes before 0. Appending the minimum breaks it
+expected map[string]interface {}{"tags":[]string{"a"}}. got map[string]interface {}{"tags":[]string{"b"}}. The value behind pointer 0 of Freeze was mutated. The tags are frozen
+`))
+}
+
+func TestConcurrencyAssertions(t *testing.T) {
+	mutex := &sync.RWMutex{}
+	mutex.RLock()
+	invariant.AlwaysLocked(mutex, "Read locked")
+	mutex.RUnlock()
+
+	owner := invariant.CurrentGoroutine()
+	invariant.AlwaysOwnedBy(owner, "Owned by the test")
+	done := make(chan string)
+	go func() {
+		done <- failure(t, func() { invariant.AlwaysOwnedBy(owner, "Owned by the test") })
+	}()
+	if msg := <-done; !strings.HasSuffix(msg, fmt.Sprintf("is owned by goroutine %d. Owned by the test\n", owner)) {
+		t.Errorf("unexpected failure: %s", msg)
+	}
+
+	guard := &invariant.Reentrancy{}
+	var recurse func(depth int)
+	recurse = func(depth int) {
+		defer invariant.NotReentrant(guard, "recurse isn't reentrant")()
+		if depth > 0 {
+			recurse(depth - 1)
+		}
+	}
+	recurse(0)
+	recurse(0)
+
+	hb := invariant.NewHappensBefore()
+	hb.Happen("written")
+	invariant.AlwaysHappenedBefore(hb, "written", "Same goroutine")
+	results := make(chan struct{})
+	go func() {
+		hb.Happen("result written")
+		hb.Release("results")
+		results <- struct{}{}
+		hb.Happen("late")
+		results <- struct{}{}
+	}()
+	<-results
+	hb.Acquire("results")
+	invariant.AlwaysHappenedBefore(hb, "result written", "Results are written before they're read")
+	<-results
+
+	out := &bytes.Buffer{}
+	out.WriteString(failure(t, func() { invariant.AlwaysLocked(mutex, "Unlocked") }))
+	out.WriteString(failure(t, func() { recurse(1) }))
+	out.WriteString(failure(t, func() { invariant.AlwaysHappenedBefore(hb, "never", "Never happened") }))
+	msg := failure(t, func() { invariant.AlwaysHappenedBefore(hb, "late", "Not released") })
+	out.WriteString(msg[:strings.Index(msg, " with goroutine")] + "\n")
+	check(t, out.String(), snap.Init(`the mutex is unlocked. Unlocked
+reentered a non-reentrant function. recurse isn't reentrant
+"never" never happened. Never happened
+"late" happened concurrently
+`))
+}
+
+func TestScanConcurrencyAssertions(t *testing.T) {
+	const src = `package foo
+
+import "github.com/james-orcales/golang_snacks/invariant"
+
+func (f *Foo) Bar() {
+	defer invariant.NotReentrant(&f.guard, "Bar isn't reentrant")()
+	invariant.AlwaysLocked(&f.mutex, "Bar holds the lock")
+	invariant.AlwaysOwnedBy(f.owner, "Bar runs on the owner")
+	invariant.AlwaysHappenedBefore(f.hb, "init", "Bar runs after init")
+}
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "foo.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sites, err := invariant.ScanAssertions(fset, file, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	for _, site := range sites {
+		fmt.Fprintf(out, "%s:%d %s %q\n", site.File, site.Line, site.Kind, site.Message)
+	}
+	check(t, out.String(), snap.Init(`foo.go:6 NotReentrant "Bar isn't reentrant"
+foo.go:7 AlwaysLocked "Bar holds the lock"
+foo.go:8 AlwaysOwnedBy "Bar runs on the owner"
+foo.go:9 AlwaysHappenedBefore "Bar runs after init"
+`))
+}
+
+func TestClassifyFlakiness(t *testing.T) {
+	const module = "example.com/foo"
+	run := func(frequencies ...int) invariant.Report {
+		report := invariant.Report{Version: invariant.ReportVersion, Module: module}
+		for i, message := range []string{"stable", "rare", "common", "gap"} {
+			report.Assertions = append(report.Assertions, invariant.ReportEntry{
+				ID:        uint64(i + 1),
+				Location:  fmt.Sprintf("foo/foo.go:%d", 10*(i+1)),
+				Kind:      "Sometimes",
+				Message:   message,
+				Frequency: frequencies[i],
+			})
+		}
+		return report
+	}
+	flakiness, err := invariant.ClassifyFlakiness(
+		run(5, 0, 2, 0),
+		run(3, 1, 0, 0),
+		run(9, 0, 4, 0),
+		run(1, 0, 1, 0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	invariant.FprintFlakiness(out, flakiness)
+	check(t, out.String(), snap.Init(`1 of 4 assertions were covered by all 4 runs.
+⚠️ 2 assertions were only covered by some runs. ⚠️
+	p=0.25 1/4 | Sometimes | rare   | foo/foo.go:20
+	p=0.75 3/4 | Sometimes | common | foo/foo.go:30
+🚨 1 assertions were never covered. 🚨
+	Sometimes | gap    | foo/foo.go:40
+`))
+
+	if _, err := invariant.ClassifyFlakiness(); err == nil {
+		t.Fatal("Classified no runs")
+	}
+}
+
+func TestAnalyzeReport(t *testing.T) {
+	now := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	report := invariant.Report{Version: invariant.ReportVersion, Module: "example.com/foo", Assertions: []invariant.ReportEntry{
+		{Location: "foo/foo.go:10", Kind: "Sometimes", Message: "rare", Frequency: 2},
+		{Location: "foo/foo.go:20", Kind: "Always", Message: "common", Frequency: 40},
+		{Location: "foo/foo.go:30", Kind: "Sometimes", Message: "allowed gap", Frequency: 0},
+		{Location: "foo/foo.go:40", Kind: "Reachable", Message: "expired gap", Frequency: 0},
+		{ID: 7, Location: "foo/foo.go:50", Kind: "Sometimes", Message: "gap", Frequency: 0},
+		{Location: "foo/foo.go:60", Kind: "SometimesCount", Message: "counted", Frequency: 3, MinFrequency: 5},
+	}}
+	options := invariant.TestOptions{
+		MinFrequency: map[string]int{"Sometimes": 3},
+		KnownMisses: []invariant.KnownMiss{
+			{Message: "allowed gap", Location: "foo.go:30", Expires: now.AddDate(0, 1, 0)},
+			{Message: "expired gap", Expires: now.AddDate(0, -1, 0)},
+			{ID: 8, Message: "gap"},
+		},
+		LeastExercised: 2,
+	}
+	result := invariant.AnalyzeReport(report, options, now)
+	if !result.Failed() || result.Code != 0 {
+		t.Fatal("AnalyzeReport fails without setting the exit code")
+	}
+	out := &bytes.Buffer{}
+	for _, format := range []invariant.ReportFormat{invariant.FormatText, invariant.FormatJUnit} {
+		if err := invariant.WriteTestResult(out, result, format); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(t, out.String(), snap.Init(`🚨 2 assertions were never true. 🚨
+	Reachable | expired gap | foo/foo.go:40
+	Sometimes | gap         | foo/foo.go:50
+🚨 2 assertions violated their thresholds. 🚨
+	     Sometimes | rare    | foo/foo.go:10 | true 2 times, expected at least 3
+	SometimesCount | counted | foo/foo.go:60 | true 3 times, expected at least 5
+🚨 The known miss of "expired gap" expired on 2026-02-01. 🚨
+1 known missed assertions were allowed.
+The 2 least-exercised invariants:
+count=2    | Sometimes      | rare    | foo/foo.go:10
+count=3    | SometimesCount | counted | foo/foo.go:60
+<?xml version="1.0" encoding="UTF-8"?>
+<testsuite name="example.com/foo" tests="6" failures="4" skipped="1">
+	<testcase name="Sometimes: rare" classname="foo/foo.go:10">
+		<failure message="true 2 times, expected at least 3"></failure>
+	</testcase>
+	<testcase name="Always: common" classname="foo/foo.go:20"></testcase>
+	<testcase name="Sometimes: allowed gap" classname="foo/foo.go:30">
+		<skipped message="known miss"></skipped>
+	</testcase>
+	<testcase name="Reachable: expired gap" classname="foo/foo.go:40">
+		<failure message="never true"></failure>
+	</testcase>
+	<testcase name="Sometimes: gap" classname="foo/foo.go:50">
+		<failure message="never true"></failure>
+	</testcase>
+	<testcase name="SometimesCount: counted" classname="foo/foo.go:60">
+		<failure message="true 3 times, expected at least 5"></failure>
+	</testcase>
+</testsuite>
+`))
+
+	if err := invariant.WriteTestResult(out, result, "yaml"); err == nil {
+		t.Fatal("Wrote an unknown format")
+	}
+	options.KnownMisses = append(options.KnownMisses, invariant.KnownMiss{ID: 7})
+	options.MinFrequency = nil
+	if result := invariant.AnalyzeReport(report, options, now); len(result.Missed) != 1 || len(result.Violations) != 1 {
+		t.Fatalf("expected the expired gap and SometimesCount to fail. got %+v", result)
+	}
+}
@@ -0,0 +1,267 @@
+package vet
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// vetConfig is the subset of the configuration that go vet passes to a vettool.
+type vetConfig struct {
+	Dir        string
+	ImportPath string
+	GoFiles    []string
+	VetxOnly   bool
+	VetxOutput string
+}
+
+// Main runs analyzers as a go vet tool when invoked by go vet with a single *.cfg argument.
+// Otherwise it runs them over the directories in its arguments, where "dir/..." includes
+// subdirectories. It exits with status 1 if anything was reported.
+//
+// Only direct invocations print JSON with -json. go vet passes -json to its tools whether or not
+// it was asked to, and it only fails when the tool does.
+func Main(analyzers ...*Analyzer) {
+	progname := filepath.Base(os.Args[0])
+	flags := flag.NewFlagSet(progname, flag.ExitOnError)
+	printVersion := flags.String("V", "", "print the version and exit")
+	printFlags := flags.Bool("flags", false, "print the analyzer flags as JSON and exit")
+	printJSON := flags.Bool("json", false, "print the diagnostics as JSON to stdout")
+	flags.Int("c", -1, "accepted for compatibility with go vet")
+	for _, analyzer := range analyzers {
+		analyzer.Flags.VisitAll(func(f *flag.Flag) {
+			flags.Var(f.Value, analyzer.Name+"."+f.Name, f.Usage)
+		})
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s [flags] [dir|dir/...]...\n       go vet -vettool=$(which %s) [packages]\n\n", progname, progname)
+		for _, analyzer := range analyzers {
+			fmt.Fprintf(flags.Output(), "%s: %s\n\n", analyzer.Name, analyzer.Doc)
+		}
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+
+	if *printVersion != "" {
+		if err := fprintVersion(os.Stdout, progname); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *printFlags {
+		fprintFlags(os.Stdout, flags)
+		return
+	}
+
+	args := flags.Args()
+	var (
+		diagnostics []finding
+		importPath  = "."
+		err         error
+	)
+	if len(args) == 1 && strings.HasSuffix(args[0], ".cfg") {
+		*printJSON = false
+		importPath, diagnostics, err = runConfig(args[0], analyzers)
+	} else {
+		if len(args) == 0 {
+			args = []string{"."}
+		}
+		diagnostics, err = runDirs(args, analyzers)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", progname, err)
+		os.Exit(1)
+	}
+	if *printJSON {
+		fprintJSON(os.Stdout, importPath, diagnostics)
+	} else {
+		for _, diagnostic := range diagnostics {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", diagnostic.Posn, diagnostic.Message)
+		}
+	}
+	if len(diagnostics) > 0 {
+		os.Exit(1)
+	}
+}
+
+// fprintVersion identifies the executable by its content so that go vet can cache the results.
+func fprintVersion(w io.Writer, progname string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(executable)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s version devel comments-go-here buildID=%x\n", progname, sha256.Sum256(content))
+	return err
+}
+
+func fprintFlags(w io.Writer, flags *flag.FlagSet) {
+	type jsonFlag struct {
+		Name  string
+		Bool  bool
+		Usage string
+	}
+	var list []jsonFlag
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Name == "V" || f.Name == "flags" || f.Name == "json" || f.Name == "c" {
+			return
+		}
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		list = append(list, jsonFlag{Name: f.Name, Bool: ok && b.IsBoolFlag(), Usage: f.Usage})
+	})
+	data, _ := json.MarshalIndent(list, "", "\t")
+	fmt.Fprintln(w, string(data))
+}
+
+// fprintJSON prints the diagnostics in the format of golang.org/x/tools/go/analysis/unitchecker,
+// which go vet relays when it is invoked with -json.
+func fprintJSON(w io.Writer, importPath string, diagnostics []finding) {
+	type jsonDiagnostic struct {
+		Posn    string `json:"posn"`
+		Message string `json:"message"`
+	}
+	byAnalyzer := make(map[string][]jsonDiagnostic)
+	for _, diagnostic := range diagnostics {
+		byAnalyzer[diagnostic.Analyzer] = append(byAnalyzer[diagnostic.Analyzer], jsonDiagnostic{Posn: diagnostic.Posn, Message: diagnostic.Message})
+	}
+	if len(byAnalyzer) == 0 {
+		return
+	}
+	data, _ := json.MarshalIndent(map[string]any{importPath: byAnalyzer}, "", "\t")
+	fmt.Fprintln(w, string(data))
+}
+
+func runConfig(path string, analyzers []*Analyzer) (importPath string, diagnostics []finding, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	var config vetConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	// go vet expects the facts file even though these analyzers don't export any.
+	if config.VetxOutput != "" {
+		if err := os.WriteFile(config.VetxOutput, nil, 0o666); err != nil {
+			return "", nil, err
+		}
+	}
+	if config.VetxOnly {
+		return config.ImportPath, nil, nil
+	}
+	diagnostics, err = analyze(config.GoFiles, analyzers)
+	return config.ImportPath, diagnostics, err
+}
+
+func runDirs(patterns []string, analyzers []*Analyzer) ([]finding, error) {
+	var diagnostics []finding
+	for _, pattern := range patterns {
+		root, isRecursive := strings.CutSuffix(pattern, "/...")
+		if pattern == "..." {
+			root, isRecursive = ".", true
+		}
+		var dirs []string
+		if isRecursive {
+			err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if !d.IsDir() {
+					return nil
+				}
+				name := d.Name()
+				if path != root && (name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+					return filepath.SkipDir
+				}
+				dirs = append(dirs, path)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			dirs = append(dirs, root)
+		}
+		for _, dir := range dirs {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				return nil, err
+			}
+			var files []string
+			for _, entry := range entries {
+				name := entry.Name()
+				if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+					continue
+				}
+				files = append(files, filepath.Join(dir, name))
+			}
+			found, err := analyze(files, analyzers)
+			if err != nil {
+				return nil, err
+			}
+			diagnostics = append(diagnostics, found...)
+		}
+	}
+	return diagnostics, nil
+}
+
+// finding is a diagnostic whose position is formatted as file:line:col.
+type finding struct {
+	Analyzer string
+	Posn     string
+	Message  string
+}
+
+// analyze parses files as one package and returns the diagnostics in the order of the files.
+func analyze(files []string, analyzers []*Analyzer) ([]finding, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	fset := token.NewFileSet()
+	parsed := make([]*ast.File, 0, len(files))
+	for _, path := range files {
+		file, err := parser.ParseFile(fset, path, nil, parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, file)
+	}
+	type reported struct {
+		analyzer string
+		Diagnostic
+	}
+	var found []reported
+	for _, analyzer := range analyzers {
+		pass := &Pass{
+			Analyzer: analyzer,
+			Fset:     fset,
+			Files:    parsed,
+			Report: func(diagnostic Diagnostic) {
+				found = append(found, reported{analyzer.Name, diagnostic})
+			},
+		}
+		if err := analyzer.Run(pass); err != nil {
+			return nil, fmt.Errorf("%s: %w", analyzer.Name, err)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Pos < found[j].Pos })
+	diagnostics := make([]finding, len(found))
+	for i, r := range found {
+		diagnostics[i] = finding{Analyzer: r.analyzer, Posn: fset.Position(r.Pos).String(), Message: r.Message}
+	}
+	return diagnostics, nil
+}
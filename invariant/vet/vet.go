@@ -0,0 +1,208 @@
+// Package vet reports assertion call sites that RegisterPackagesForAnalysis would reject at test
+// time, along with common misuses of the assertions. It mirrors the shape of
+// golang.org/x/tools/go/analysis without depending on it, so it works without network access.
+//
+// Use it through go vet:
+//
+//	go build -o /tmp/invariantvet github.com/james-orcales/golang_snacks/invariant/cmd/invariantvet
+//	go vet -vettool=/tmp/invariantvet ./...
+//
+// or standalone:
+//
+//	go run github.com/james-orcales/golang_snacks/invariant/cmd/invariantvet ./...
+package vet
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+)
+
+const invariantImportPath = "github.com/james-orcales/golang_snacks/invariant"
+
+// Analyzer is a static check over the syntax of a package.
+type Analyzer struct {
+	Name  string
+	Doc   string
+	Flags flag.FlagSet
+	Run   func(pass *Pass) error
+}
+
+// Pass is one run of an Analyzer over a package.
+type Pass struct {
+	Analyzer *Analyzer
+	Fset     *token.FileSet
+	// Files excludes test files unless the package is a test variant.
+	Files  []*ast.File
+	Report func(diagnostic Diagnostic)
+}
+
+func (pass *Pass) Reportf(pos token.Pos, format string, args ...any) {
+	pass.Report(Diagnostic{Pos: pos, Message: fmt.Sprintf(format, args...)})
+}
+
+type Diagnostic struct {
+	Pos     token.Pos
+	Message string
+}
+
+var Invariant = &Analyzer{
+	Name: "invariant",
+	Doc: `check invariant assertion call sites
+
+The analyzer of the invariant package locates assertions by parsing the source. It requires the
+package to be imported as "invariant", the message to be the last argument as a string literal and
+at most one assertion per line. This also reports deferred assertions whose condition is evaluated
+immediately and assertions inside loops, which inflate their frequency.
+
+An assertion that has to run on every iteration, such as a bounds check in a hot loop, is allowed by
+a //invariant:loop comment at the end of its line.`,
+	Run: run,
+}
+
+var checkLoops = true
+
+// loopDirective allows the assertion on its line to be inside a loop.
+const loopDirective = "//invariant:loop"
+
+func init() {
+	Invariant.Flags.BoolVar(&checkLoops, "loop", checkLoops, "report Always assertions that are evaluated on every iteration of a loop")
+}
+
+func run(pass *Pass) error {
+	for _, file := range pass.Files {
+		name := ""
+		for _, spec := range file.Imports {
+			path, err := strconv.Unquote(spec.Path.Value)
+			if err != nil || path != invariantImportPath {
+				continue
+			}
+			name = "invariant"
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			if name != "invariant" && name != "_" {
+				pass.Reportf(spec.Pos(), `the invariant package must be imported as "invariant" for its assertions to be analyzed. got %q`, name)
+			}
+		}
+		if name == "" || name == "_" || name == "." {
+			continue
+		}
+		c := checker{pass: pass, name: name, lines: make(map[int]bool), allowedLoops: make(map[int]bool)}
+		for _, group := range file.Comments {
+			for _, comment := range group.List {
+				if strings.TrimSpace(comment.Text) == loopDirective {
+					c.allowedLoops[pass.Fset.Position(comment.Pos()).Line] = true
+				}
+			}
+		}
+		c.walk(file, 0)
+	}
+	return nil
+}
+
+type checker struct {
+	pass *Pass
+	// name is the name that the invariant package is imported as in the current file.
+	name string
+	// lines that already have an assertion.
+	lines map[int]bool
+	// allowedLoops are the lines of loopDirective comments.
+	allowedLoops map[int]bool
+}
+
+// walk tracks the number of loops around each node. Function literals start over since they may
+// be called once for the whole loop.
+func (c *checker) walk(root ast.Node, loops int) {
+	ast.Inspect(root, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ForStmt:
+			if n.Init != nil {
+				c.walk(n.Init, loops)
+			}
+			if n.Cond != nil {
+				c.walk(n.Cond, loops)
+			}
+			if n.Post != nil {
+				c.walk(n.Post, loops+1)
+			}
+			c.walk(n.Body, loops+1)
+			return false
+		case *ast.RangeStmt:
+			c.walk(n.X, loops)
+			c.walk(n.Body, loops+1)
+			return false
+		case *ast.FuncLit:
+			// Closures that aren't called in place, such as the aggregate of XAlways, run once
+			// per call rather than once per iteration.
+			c.walk(n.Body, 0)
+			return false
+		case *ast.DeferStmt:
+			if kind := c.assertion(n.Call); kind != "" && !strings.HasPrefix(kind, "X") && kind != "Reachable" {
+				c.pass.Reportf(n.Call.Lparen, "the condition of deferred invariant.%s is evaluated immediately. Wrap the assertion in a closure", kind)
+			}
+		case *ast.CallExpr:
+			c.check(n, loops)
+			if lit, ok := ast.Unparen(n.Fun).(*ast.FuncLit); ok {
+				c.walk(lit.Body, loops)
+				for _, arg := range n.Args {
+					c.walk(arg, loops)
+				}
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// assertion returns the kind of assertion that call is or an empty string.
+func (c *checker) assertion(call *ast.CallExpr) string {
+	fun := call.Fun
+	switch index := fun.(type) {
+	case *ast.IndexExpr:
+		fun = index.X
+	case *ast.IndexListExpr:
+		fun = index.X
+	}
+	sel, ok := fun.(*ast.SelectorExpr)
+	if !ok {
+		return ""
+	}
+	ident, ok := sel.X.(*ast.Ident)
+	if !ok || ident.Name != c.name || invariant.AssertionParameters(sel.Sel.Name) == 0 {
+		return ""
+	}
+	return sel.Sel.Name
+}
+
+func (c *checker) check(call *ast.CallExpr, loops int) {
+	kind := c.assertion(call)
+	if kind == "" {
+		return
+	}
+	params := invariant.AssertionParameters(kind)
+	if call.Ellipsis.IsValid() || len(call.Args) != params {
+		c.pass.Reportf(call.Lparen, "invariant.%s has %d parameters with the message as the last one", kind, params)
+		return
+	}
+	if literal, ok := call.Args[len(call.Args)-1].(*ast.BasicLit); !ok || literal.Kind != token.STRING {
+		c.pass.Reportf(call.Args[len(call.Args)-1].Pos(), "the message of invariant.%s must be a string literal", kind)
+	}
+
+	// RegisterPackagesForAnalysis tracks an assertion by the line of its opening parenthesis.
+	line := c.pass.Fset.Position(call.Lparen).Line
+	if c.lines[line] {
+		c.pass.Reportf(call.Lparen, "invariant.%s shares line %d with another assertion. Assertions are tracked by line", kind, line)
+	}
+	c.lines[line] = true
+
+	isAlways := strings.HasPrefix(kind, "Always") || strings.HasPrefix(kind, "XAlways") || kind == "Ensure"
+	if checkLoops && loops > 0 && isAlways && !c.allowedLoops[line] {
+		c.pass.Reportf(call.Lparen, "invariant.%s inside a loop is counted on every iteration. Aggregate the loop into a closure that returns a single bool", kind)
+	}
+}
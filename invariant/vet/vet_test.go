@@ -0,0 +1,98 @@
+package vet_test
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/james-orcales/golang_snacks/invariant/vet"
+	"github.com/james-orcales/golang_snacks/snap"
+)
+
+func run(t *testing.T, src string) string {
+	t.Helper()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "foo.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &strings.Builder{}
+	pass := &vet.Pass{
+		Analyzer: vet.Invariant,
+		Fset:     fset,
+		Files:    []*ast.File{file},
+		Report: func(diagnostic vet.Diagnostic) {
+			fmt.Fprintf(out, "%s: %s\n", fset.Position(diagnostic.Pos), diagnostic.Message)
+		},
+	}
+	if err := vet.Invariant.Run(pass); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestInvariant(t *testing.T) {
+	const src = `package foo
+
+import "github.com/james-orcales/golang_snacks/invariant"
+
+const msg = "not a literal"
+
+func Foo(xs []int) {
+	invariant.Always(len(xs) > 0, msg)
+	invariant.Always(true, "a"); invariant.Sometimes(true, "b")
+	invariant.Always(len(xs) > 0, "too", "many")
+	defer invariant.Always(len(xs) > 0, "evaluated immediately")
+	defer invariant.XAlways(func() bool { return len(xs) > 0 }, "evaluated when deferred")
+	defer func() {
+		invariant.Always(len(xs) > 0, "wrapped in a closure")
+	}()
+	for _, x := range xs {
+		invariant.AlwaysLess(0, x, "counted per element")
+		invariant.Sometimes(x == 0, "frequency is meaningful")
+		func() {
+			invariant.Always(x >= 0, "inside a closure called in place")
+		}()
+		invariant.Always(x != 1, "allowed") //invariant:loop
+		invariant.Always(x != 2, "not allowed by the line above")
+		invariant.
+			Always(x != 3, "tracked at the parenthesis")
+	}
+	invariant.XAlways(func() bool {
+		for _, x := range xs {
+			if x < 0 {
+				return false
+			}
+		}
+		return true
+	}, "aggregated")
+}
+`
+	if !snap.Init(`foo.go:8:32: the message of invariant.Always must be a string literal
+foo.go:9:50: invariant.Sometimes shares line 9 with another assertion. Assertions are tracked by line
+foo.go:10:18: invariant.Always has 2 parameters with the message as the last one
+foo.go:11:24: the condition of deferred invariant.Always is evaluated immediately. Wrap the assertion in a closure
+foo.go:17:23: invariant.AlwaysLess inside a loop is counted on every iteration. Aggregate the loop into a closure that returns a single bool
+foo.go:20:20: invariant.Always inside a loop is counted on every iteration. Aggregate the loop into a closure that returns a single bool
+foo.go:23:19: invariant.Always inside a loop is counted on every iteration. Aggregate the loop into a closure that returns a single bool
+foo.go:25:10: invariant.Always inside a loop is counted on every iteration. Aggregate the loop into a closure that returns a single bool
+`).IsEqual(run(t, src)) {
+		t.Fatal("Snapshot mismatch")
+	}
+
+	const renamed = `package foo
+
+import inv "github.com/james-orcales/golang_snacks/invariant"
+
+func Foo() {
+	inv.Always(true, "not analyzed")
+}
+`
+	if !snap.Init(`foo.go:3:8: the invariant package must be imported as "invariant" for its assertions to be analyzed. got "inv"
+`).IsEqual(run(t, renamed)) {
+		t.Fatal("Snapshot mismatch")
+	}
+}
@@ -16,3 +16,17 @@ func XAlwaysErrIs(fn func() error, targets []error, msg string) {
 
 func XAlwaysErrIsNot(fn func() error, targets []error, msg string) {
 }
+
+func XAlwaysValid(x Invariants, msg string) {
+}
+
+func CheckAround(x Invariants, fn func(), msg string) {
+	fn()
+}
+
+func Freeze(pointers ...any) Frozen {
+	return Frozen{}
+}
+
+func XAlwaysUnchanged(frozen Frozen, msg string) {
+}
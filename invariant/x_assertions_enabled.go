@@ -5,6 +5,7 @@ package invariant
 import (
 	"errors"
 	"fmt"
+	"reflect"
 )
 
 /*
@@ -29,13 +30,13 @@ func XAlways(fn func() bool, msg string) {
 	if fn() {
 		registerAssertion()
 	} else {
-		assertionFailureCallback(fmt.Sprintf("%s: %s\n", AssertionFailureMsgPrefix, msg))
+		assertionFailureCallback(msg)
 	}
 }
 
 //go:noinline
 func XSometimes(fn func() bool, msg string) {
-	if !IsRunningUnderGoTest || !fn() {
+	if !IsRunningUnderGoTest && telemetrySampleEvery.Load() == 0 || !fn() {
 		return
 	}
 	registerAssertion()
@@ -49,7 +50,7 @@ func XAlwaysNil(fn func() interface{}, msg string) {
 	if x == nil {
 		registerAssertion()
 	} else {
-		assertionFailureCallback(fmt.Sprintf("%s: expected nil. got %v. %s\n", AssertionFailureMsgPrefix, x, msg))
+		assertionFailureCallback(fmt.Sprintf("expected nil. got %v. %s", x, msg))
 	}
 }
 
@@ -68,7 +69,7 @@ func XAlwaysErrIs(fn func() error, targets []error, msg string) {
 			return
 		}
 	}
-	assertionFailureCallback(fmt.Sprintf("%s: error did not match any targets. got %q. %s\n", AssertionFailureMsgPrefix, actual, msg))
+	assertionFailureCallback(fmt.Sprintf("error did not match any targets. got %q. %s", actual, msg))
 }
 
 // XAlwaysErrIsNot evaluates fn and calls assertionFailureCallback if the returned error matches any target.
@@ -82,9 +83,149 @@ func XAlwaysErrIsNot(fn func() error, targets []error, msg string) {
 	actual := fn()
 	for _, t := range targets {
 		if errors.Is(actual, t) {
-			assertionFailureCallback(fmt.Sprintf("error unexpectedly matched a target. got %q. %s\n", actual, msg))
+			assertionFailureCallback(fmt.Sprintf("error unexpectedly matched a target. got %q. %s", actual, msg))
 			return
 		}
 	}
 	registerAssertion()
 }
+
+// XAlwaysValid calls assertionFailureCallback if x.Invariants returns an error.
+//
+//go:noinline
+func XAlwaysValid(x Invariants, msg string) {
+	if err := x.Invariants(); err == nil {
+		registerAssertion()
+	} else {
+		assertionFailureCallback(fmt.Sprintf("%T is invalid: %s. %s", x, err, msg))
+	}
+}
+
+// CheckAround validates x before and after calling fn, which is usually a method of x. Under
+// disable_assertions and disable_x_assertions, it only calls fn.
+//
+//	func (tree *Tree) Insert(key int) {
+//		invariant.CheckAround(tree, func() {
+//			tree.insert(key)
+//		}, "Insert keeps the tree balanced")
+//	}
+//
+//go:noinline
+func CheckAround(x Invariants, fn func(), msg string) {
+	before := x.Invariants()
+	if before != nil {
+		assertionFailureCallback(fmt.Sprintf("%T is invalid before the call: %s. %s", x, before, msg))
+	}
+	fn()
+	if err := x.Invariants(); err != nil {
+		assertionFailureCallback(fmt.Sprintf("%T is invalid after the call: %s. %s", x, err, msg))
+	} else if before == nil {
+		registerAssertion()
+	}
+}
+
+// Freeze deep copies the values behind pointers so that XAlwaysUnchanged can tell whether a call
+// mutated them. Slices, arrays, maps, pointers, interfaces and exported struct fields are copied.
+// Unexported fields are copied shallowly, so mutations through them go unnoticed.
+//
+//	func (d *Differ) Diff() {
+//		frozen := invariant.Freeze(&d.Old, &d.New)
+//		defer func() {
+//			invariant.XAlwaysUnchanged(frozen, "Diff only mutates Differ.Edits")
+//		}()
+//		...
+//	}
+func Freeze(pointers ...any) Frozen {
+	frozen := Frozen{}
+	for _, pointer := range pointers {
+		v := reflect.ValueOf(pointer)
+		if v.Kind() != reflect.Pointer || v.IsNil() {
+			assertionFailureCallback(fmt.Sprintf("invariant.Freeze takes non-nil pointers. got %T", pointer))
+			return Frozen{}
+		}
+		frozen.pointers = append(frozen.pointers, v)
+		frozen.copies = append(frozen.copies, deepCopy(v.Elem(), make(map[frozenPointer]reflect.Value)))
+	}
+	return frozen
+}
+
+// XAlwaysUnchanged calls assertionFailureCallback if any value behind the pointers given to Freeze
+// changed since.
+//
+//go:noinline
+func XAlwaysUnchanged(frozen Frozen, msg string) {
+	for i, pointer := range frozen.pointers {
+		current, original := pointer.Elem().Interface(), frozen.copies[i].Interface()
+		if !reflect.DeepEqual(current, original) {
+			assertionFailureCallback(formatComparison(current, original, fmt.Sprintf("The value behind pointer %d of Freeze was mutated. %s", i, msg)))
+			return
+		}
+	}
+	registerAssertion()
+}
+
+// frozenPointer identifies a pointer that was already copied so that cycles are copied once.
+type frozenPointer struct {
+	typ     reflect.Type
+	address uintptr
+}
+
+func deepCopy(v reflect.Value, copied map[frozenPointer]reflect.Value) reflect.Value {
+	t := v.Type()
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return reflect.Zero(t)
+		}
+		key := frozenPointer{t, v.Pointer()}
+		if c, ok := copied[key]; ok {
+			return c
+		}
+		c := reflect.New(t.Elem())
+		copied[key] = c
+		c.Elem().Set(deepCopy(v.Elem(), copied))
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return reflect.Zero(t)
+		}
+		c := reflect.MakeSlice(t, v.Len(), v.Len())
+		for i := range v.Len() {
+			c.Index(i).Set(deepCopy(v.Index(i), copied))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(t).Elem()
+		for i := range v.Len() {
+			c.Index(i).Set(deepCopy(v.Index(i), copied))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return reflect.Zero(t)
+		}
+		c := reflect.MakeMapWithSize(t, v.Len())
+		for entry := v.MapRange(); entry.Next(); {
+			c.SetMapIndex(deepCopy(entry.Key(), copied), deepCopy(entry.Value(), copied))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(t).Elem()
+		c.Set(v)
+		for i := range t.NumField() {
+			if t.Field(i).IsExported() {
+				c.Field(i).Set(deepCopy(v.Field(i), copied))
+			}
+		}
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return reflect.Zero(t)
+		}
+		c := reflect.New(t).Elem()
+		c.Set(deepCopy(v.Elem(), copied))
+		return c
+	default:
+		return v
+	}
+}
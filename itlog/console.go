@@ -0,0 +1,170 @@
+package itlog
+
+import (
+	"io"
+	"sync"
+
+	"github.com/james-orcales/golang_snacks/internal/term"
+	"github.com/james-orcales/golang_snacks/invariant"
+)
+
+const (
+	// ConsoleMessageWidth is the column at which the context starts in console output. Messages
+	// longer than this push their context further right instead of being truncated.
+	ConsoleMessageWidth = 48
+
+	// Set this environment variable to any non-empty value to disable colored output.
+	// Reference: https://no-color.org
+	NoColorEnv = term.NoColorEnv
+)
+
+// ConsoleWriter re-renders the native format into colored, aligned output for development. It
+// wraps the destination writer so the Logger itself stays unaware of the presentation:
+//
+//	lgr := itlog.New(itlog.NewConsoleWriter(os.Stderr), itlog.LevelDebug)
+//	lgr.Info().Str("user", "james").Msg("logged in")
+//
+//	// 2000-01-31T23:59:59Z INF logged in                                        user="james"
+//
+// Lines that aren't in the native format are written through as is.
+type ConsoleWriter struct {
+	Writer    io.Writer
+	IsColored bool
+	// Guards buffer since a Logger may write from multiple goroutines.
+	mutex  sync.Mutex
+	buffer []byte
+}
+
+// NewConsoleWriter enables colors only if writer is a terminal and NO_COLOR is unset.
+func NewConsoleWriter(writer io.Writer) *ConsoleWriter {
+	invariant.Always(writer != nil, "ConsoleWriter needs a destination writer")
+	isColored := term.IsColored(writer)
+	invariant.Sometimes(isColored, "ConsoleWriter is colored")
+	invariant.Sometimes(!isColored, "ConsoleWriter is not colored")
+	return &ConsoleWriter{
+		Writer:    writer,
+		IsColored: isColored,
+		buffer:    make([]byte, 0, DefaultEventBufferCapacity),
+	}
+}
+
+// Write expects exactly one log entry per call, which is what Event.Msg does.
+func (cw *ConsoleWriter) Write(p []byte) (n int, err error) {
+	if len(p) < HeaderCapacity+len("|\n") || p[len(p)-1] != '\n' ||
+		p[TimestampCapacity] != ComponentDelimiter ||
+		p[TimestampCapacity+1+LevelCapacity] != ComponentDelimiter ||
+		p[HeaderCapacity] != ComponentDelimiter {
+		invariant.Sometimes(true, "ConsoleWriter received a line that is not in the native format")
+		return cw.Writer.Write(p)
+	}
+
+	cw.mutex.Lock()
+	defer cw.mutex.Unlock()
+
+	timestamp := p[:TimestampCapacity]
+	level := p[TimestampCapacity+1 : TimestampCapacity+1+LevelCapacity]
+	message := p[TimestampCapacity+1+LevelCapacity+1 : HeaderCapacity]
+	context := p[HeaderCapacity+1 : len(p)-1]
+
+	buf := cw.buffer[:0]
+	buf = cw.appendColored(buf, term.Dim, timestamp)
+	buf = append(buf, ' ')
+	buf = cw.appendColored(buf, levelColor(level), level)
+
+	// The native format pads the message with whitespace up to MessageCapacity.
+	end := len(message)
+	for end > 0 && message[end-1] == ' ' {
+		end--
+	}
+	message = message[:end]
+	if len(message) > 0 || len(context) > 0 {
+		buf = append(buf, ' ')
+		buf = append(buf, message...)
+	}
+
+	if len(context) > 0 {
+		invariant.Sometimes(true, "ConsoleWriter received a line with context")
+		for i := len(message); i < ConsoleMessageWidth; i++ {
+			buf = append(buf, ' ')
+		}
+		buf = append(buf, ' ')
+		buf = cw.appendContext(buf, context)
+	}
+	buf = append(buf, '\n')
+	cw.buffer = buf
+
+	if _, err := cw.Writer.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// appendContext splits the context on ComponentDelimiter, skipping delimiters that are inside
+// quoted string values, and separates each key=value pair with a space.
+func (cw *ConsoleWriter) appendContext(dst, context []byte) []byte {
+	isQuoted := false
+	isEscaped := false
+	start := 0
+	for i, ch := range context {
+		switch {
+		case isEscaped:
+			isEscaped = false
+		case ch == '\\' && isQuoted:
+			isEscaped = true
+		case ch == Quote:
+			isQuoted = !isQuoted
+		case ch == ComponentDelimiter && !isQuoted:
+			if start > 0 {
+				dst = append(dst, ' ')
+			}
+			dst = cw.appendPair(dst, context[start:i])
+			start = i + 1
+		}
+	}
+	invariant.Always(!isQuoted, "Quoted context values are always closed")
+	invariant.Always(start == len(context), "Context always ends with ComponentDelimiter")
+	return dst
+}
+
+func (cw *ConsoleWriter) appendPair(dst, pair []byte) []byte {
+	i := 0
+	for i < len(pair) && pair[i] != KeyValDelimiter {
+		i++
+	}
+	invariant.Always(i < len(pair), "Context pair has a KeyValDelimiter")
+	key, val := pair[:i], pair[i+1:]
+
+	color := term.Cyan
+	if string(key) == "error" {
+		invariant.Sometimes(true, "ConsoleWriter highlights error key")
+		color = term.Red
+	}
+	dst = cw.appendColored(dst, color, key)
+	dst = append(dst, KeyValDelimiter)
+	return append(dst, val...)
+}
+
+func (cw *ConsoleWriter) appendColored(dst []byte, color string, src []byte) []byte {
+	if !cw.IsColored {
+		return append(dst, src...)
+	}
+	dst = append(dst, color...)
+	dst = append(dst, src...)
+	return append(dst, term.Reset...)
+}
+
+func levelColor(level []byte) string {
+	switch bytesToStringUnsafe(level) {
+	case "DBG":
+		return term.Magenta
+	case "INF":
+		return term.Green
+	case "WRN":
+		return term.Yellow
+	case "ERR":
+		return term.Red
+	default:
+		invariant.Unreachable("Level word is either DBG, INF, WRN, or ERR")
+		return term.Reset
+	}
+}
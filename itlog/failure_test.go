@@ -0,0 +1,38 @@
+//go:build !disable_assertions
+
+package itlog_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+	"github.com/james-orcales/golang_snacks/itlog"
+	"github.com/james-orcales/golang_snacks/snap"
+)
+
+func TestLogAssertionFailure(t *testing.T) {
+	lgr := itlog.New(StdoutBuffer, itlog.LevelDebug)
+	invariant.SetFailurePolicy(invariant.FailurePolicy{
+		Rules:  []invariant.FailureRule{{Package: "github.com/james-orcales/golang_snacks/itlog_test", Mode: invariant.FailureLog}},
+		Logger: lgr,
+	})
+	defer invariant.SetFailurePolicy(invariant.FailurePolicy{})
+
+	invariant.Always(false, "Logged instead of panicking")
+	StderrBuffer.Reset()
+	// The location is absolute.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := strings.ReplaceAll(StdoutBuffer.String(), wd, "itlog")
+	StdoutBuffer.Reset()
+	StdoutBuffer.WriteString(stdout)
+	check(t, snap.Init(`Stdout:
+2000-01-31T23:59:59Z|ERR|Assertion failure                                                               |kind="Always"|location="itlog/failure_test.go:23"|message="Logged instead of panicking"|
+
+Stderr:
+`))
+}
@@ -2,6 +2,7 @@ package itlog
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"reflect"
@@ -223,6 +224,16 @@ func (lgr *Logger) Error(errs ...error) *Event {
 	return ev
 }
 
+// LogAssertionFailure implements invariant.FailureLogger so that assertion failures can be logged
+// and execution continues under invariant.FailureLog.
+func (lgr *Logger) LogAssertionFailure(failure *invariant.AssertionFailure) {
+	lgr.Error().
+		Str("kind", failure.Kind).
+		Str("location", failure.Location).
+		Str("message", failure.Message).
+		Msg("Assertion failure")
+}
+
 func (lgr *Logger) WithData(key, val []byte) *Logger {
 	if lgr == nil {
 		invariant.Sometimes(true, "Logger.WithData Logger is nil")
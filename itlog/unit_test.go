@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -393,3 +395,66 @@ func TestBeginDone(t *testing.T) {
 Stderr:
 `))
 }
+
+func TestConsole(t *testing.T) {
+	cw := itlog.NewConsoleWriter(StdoutBuffer)
+	if cw.IsColored {
+		t.Fatal("ConsoleWriter is colored when writing to a buffer")
+	}
+	lgr := itlog.New(cw, itlog.LevelDebug).WithStr("user", "james")
+	lgr.Debug().Msg("short")
+	lgr.Info().Str("quoted", "a|b=\"c\\").Int("count", 3).Msg("delimiters inside quotes")
+	lgr.Warn().Msg("this message is longer than the console message width so context is pushed")
+	lgr.Error(errors.New("boom")).Msg("")
+	itlog.New(cw, itlog.LevelInfo).Info().Msg("no context")
+	fmt.Fprint(cw, "not a log line\n")
+
+	check(t, snap.Init(`Stdout:
+2000-01-31T23:59:59Z DBG short                                            user="james"
+2000-01-31T23:59:59Z INF delimiters inside quotes                         user="james" quoted="a|b=\"c\\" count=3
+2000-01-31T23:59:59Z WRN this message is longer than the console message width so context is pushed user="james"
+2000-01-31T23:59:59Z ERR                                                  user="james" error="boom"
+2000-01-31T23:59:59Z INF no context
+not a log line
+
+Stderr:
+`))
+}
+
+func TestConsoleColor(t *testing.T) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+
+	t.Setenv(itlog.NoColorEnv, "1")
+	if itlog.NewConsoleWriter(devNull).IsColored {
+		t.Fatal("ConsoleWriter is colored despite NO_COLOR")
+	}
+	t.Setenv(itlog.NoColorEnv, "")
+	if !itlog.NewConsoleWriter(devNull).IsColored {
+		t.Fatal("ConsoleWriter is not colored when writing to a character device")
+	}
+
+	cw := itlog.NewConsoleWriter(StdoutBuffer)
+	cw.IsColored = true
+	lgr := itlog.New(cw, itlog.LevelDebug)
+	lgr.Debug().Msg("")
+	lgr.Info().Msg("")
+	lgr.Warn().Str("key", "val").Msg("")
+	lgr.Error(errors.New("boom")).Msg("")
+
+	// Escape sequences are made visible so the snapshot is readable.
+	actual := strings.ReplaceAll(StdoutBuffer.String(), "\033", "ESC")
+	StdoutBuffer.Reset()
+	StdoutBuffer.WriteString(actual)
+	check(t, snap.Init(`Stdout:
+ESC[2m2000-01-31T23:59:59ZESC[0m ESC[35mDBGESC[0m
+ESC[2m2000-01-31T23:59:59ZESC[0m ESC[32mINFESC[0m
+ESC[2m2000-01-31T23:59:59ZESC[0m ESC[33mWRNESC[0m                                                  ESC[36mkeyESC[0m="val"
+ESC[2m2000-01-31T23:59:59ZESC[0m ESC[31mERRESC[0m                                                  ESC[31merrorESC[0m="boom"
+
+Stderr:
+`))
+}
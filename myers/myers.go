@@ -26,6 +26,17 @@ type Differ struct {
 	OldStr, NewStr string
 }
 
+// AssertionDiff diffs the operands of failed comparison assertions. Opt in with:
+//
+//	invariant.AssertionDiffHook = myers.AssertionDiff
+func AssertionDiff(expected, actual string) string {
+	d := New(expected, actual)
+	if strings.Contains(expected, "\n") || strings.Contains(actual, "\n") {
+		return d.LineDiff()
+	}
+	return d.Diff()
+}
+
 func New(old, new string) *Differ {
 	return &Differ{
 		Old:    []rune(old),
@@ -45,11 +56,11 @@ func (d *Differ) Reset() {
 func (dfr *Differ) LineDiff() string {
 	{
 		before := *dfr
+		frozen := invariant.Freeze(&dfr.Old, &dfr.New)
 		defer func() {
 			invariant.Always(before.OldStr == dfr.OldStr, "LineDiff only mutates Differ.Edits")
 			invariant.Always(before.NewStr == dfr.NewStr, "LineDiff only mutates Differ.Edits")
-			invariant.XAlways(func() bool { return slices.Equal(before.Old, dfr.Old) }, "LineDiff only mutates Differ.Edits")
-			invariant.XAlways(func() bool { return slices.Equal(before.New, dfr.New) }, "LineDiff only mutates Differ.Edits")
+			invariant.XAlwaysUnchanged(frozen, "LineDiff only mutates Differ.Edits")
 		}()
 	}
 	if dfr.OldStr == dfr.NewStr {
@@ -124,10 +135,22 @@ func (dfr *Differ) LineDiff() string {
 	return strings.Join(result, "\n")
 }
 
+// Invariants reports edits of an unknown kind.
+func (d *Differ) Invariants() error {
+	for i, edit := range d.Edits {
+		if edit.Kind != EditRetain && edit.Kind != EditDelete && edit.Kind != EditInsert {
+			return fmt.Errorf("edit %d has unknown kind %d", i, edit.Kind)
+		}
+	}
+	return nil
+}
+
 func (d *Differ) Diff() string {
 	before := d
-	d.OptimizedDiff()
-	d.MergeShiftDiffCleanup()
+	invariant.CheckAround(d, func() {
+		d.OptimizedDiff()
+		d.MergeShiftDiffCleanup()
+	}, "Diff produces known edits")
 	invariant.XAlways(func() bool {
 		old, new := d.rebuildStringFromEdits()
 		return (before.OldStr == old) == (before.NewStr == new)
@@ -168,11 +191,11 @@ func (d Differ) String() string {
 func (d *Differ) MergeShiftDiffCleanup() {
 	{
 		before := *d
+		frozen := invariant.Freeze(&d.Old, &d.New)
 		defer func() {
 			invariant.Always(before.OldStr == d.OldStr, "MergeShiftDiffCleanup only mutates Differ.Edits")
 			invariant.Always(before.NewStr == d.NewStr, "MergeShiftDiffCleanup only mutates Differ.Edits")
-			invariant.XAlways(func() bool { return slices.Equal(before.Old, d.Old) }, "MergeShiftDiffCleanup only mutates Differ.Edits")
-			invariant.XAlways(func() bool { return slices.Equal(before.New, d.New) }, "MergeShiftDiffCleanup only mutates Differ.Edits")
+			invariant.XAlwaysUnchanged(frozen, "MergeShiftDiffCleanup only mutates Differ.Edits")
 			invariant.XAlways(func() bool {
 				old, new := d.rebuildStringFromEdits()
 				return (before.OldStr == old) == (before.NewStr == new)
@@ -272,7 +295,7 @@ func (d *Differ) MergeShiftDiffCleanup() {
 			prev := &result[len(result)-1]
 			next := &d.Edits[offset+1]
 			if prev.Kind == EditRetain && next.Kind == EditRetain {
-				invariant.Always(edit.Kind != EditRetain, "Edit kinds are alternated")
+				invariant.Always(edit.Kind != EditRetain, "Edit kinds are alternated") //invariant:loop
 				if runesHaveSuffix(edit.Data, prev.Data) {
 					invariant.Sometimes(true, "Edit is shifted: +A =BA +C -> +AB =AC")
 					isShifted = true
@@ -300,11 +323,11 @@ func (d *Differ) MergeShiftDiffCleanup() {
 func (d *Differ) OptimizedDiff() {
 	{
 		before := *d
+		frozen := invariant.Freeze(&d.Old, &d.New)
 		defer func() {
 			invariant.Always(before.OldStr == d.OldStr, "OptimizedDiff only mutates Differ.Edits")
 			invariant.Always(before.NewStr == d.NewStr, "OptimizedDiff only mutates Differ.Edits")
-			invariant.XAlways(func() bool { return slices.Equal(before.Old, d.Old) }, "OptimizedDiff only mutates Differ.Edits")
-			invariant.XAlways(func() bool { return slices.Equal(before.New, d.New) }, "OptimizedDiff only mutates Differ.Edits")
+			invariant.XAlwaysUnchanged(frozen, "OptimizedDiff only mutates Differ.Edits")
 			invariant.XAlways(func() bool {
 				old, new := d.rebuildStringFromEdits()
 				return (before.OldStr == old) == (before.NewStr == new)
@@ -421,11 +444,11 @@ func (d *Differ) OptimizedDiff() {
 func (d *Differ) AlgorithmDiff() {
 	{
 		before := *d
+		frozen := invariant.Freeze(&d.Old, &d.New)
 		defer func() {
 			invariant.Always(before.OldStr == d.OldStr, "AlgorithmDiff only mutates Differ.Edits")
 			invariant.Always(before.NewStr == d.NewStr, "AlgorithmDiff only mutates Differ.Edits")
-			invariant.XAlways(func() bool { return slices.Equal(before.Old, d.Old) }, "AlgorithmDiff only mutates Differ.Edits")
-			invariant.XAlways(func() bool { return slices.Equal(before.New, d.New) }, "AlgorithmDiff only mutates Differ.Edits")
+			invariant.XAlwaysUnchanged(frozen, "AlgorithmDiff only mutates Differ.Edits")
 
 			cond := (string(before.Old) == before.OldStr) == (string(before.New) == before.NewStr)
 			invariant.Sometimes(cond, "Runes contain the actual text")
@@ -487,26 +510,26 @@ func (d *Differ) AlgorithmDiff() {
 				}
 				y = x - k
 
-				invariant.Always(x >= k, "")
+				invariant.Always(x >= k, "") //invariant:loop
 				invariant.Sometimes(x == k, "This node has never been reached before")
 				invariant.Sometimes(x > k, "The node prior to insertion created a snake")
 
-				invariant.Always(tracker[kOffset] >= prevTracker[kOffset], "Furthest-reaching X increases across depths on same diagonal")
+				invariant.Always(tracker[kOffset] >= prevTracker[kOffset], "Furthest-reaching X increases across depths on same diagonal") //invariant:loop
 				if k < depth {
-					invariant.Always(x >= prevTracker[kOffset+1], "Furthest-reaching X increases across depths from above diagonal")
+					invariant.Always(x >= prevTracker[kOffset+1], "Furthest-reaching X increases across depths from above diagonal") //invariant:loop
 				}
 				if k > -depth {
-					invariant.Always(x >= prevTracker[kOffset-1], "Furthest-reaching X increases across depths on below diagonal")
+					invariant.Always(x >= prevTracker[kOffset-1], "Furthest-reaching X increases across depths on below diagonal") //invariant:loop
 					if isInsert {
 						prevK := k + 1
 						prevY := prevX - prevK
-						invariant.Always(x == prevX, "Insert only increments y")
-						invariant.Always(y == prevY+1, "Insert only increments y")
+						invariant.Always(x == prevX, "Insert only increments y")   //invariant:loop
+						invariant.Always(y == prevY+1, "Insert only increments y") //invariant:loop
 					} else {
 						prevK := k - 1
 						prevY := prevX - prevK
-						invariant.Always(x == prevX+1, "Delete only increments x")
-						invariant.Always(y == prevY, "Delete only increments x")
+						invariant.Always(x == prevX+1, "Delete only increments x") //invariant:loop
+						invariant.Always(y == prevY, "Delete only increments x")   //invariant:loop
 					}
 				}
 
@@ -1,15 +1,12 @@
 package sim
 
 import (
-	"math/rand/v2"
 	"sync"
 	stdtime "time"
 
 	"github.com/james-orcales/golang_snacks/invariant"
 )
 
-// TODO: Have a default seed based on the current git commit hash.
-
 const (
 	Nanosecond  = 1
 	Microsecond = Nanosecond * 1000
@@ -134,6 +131,14 @@ type Time interface {
 	// Nanoseconds elapsed since UNIX epoch. Equivalent to stdtime.Now().UnixNano()
 	// Virtual clocks should Advance by some amount with each call to simulate syscall overhead.
 	Realtime() Moment
+
+	// Equivalent to stdtime.NewTimer(), stdtime.NewTicker(), stdtime.After() and
+	// stdtime.AfterFunc(). Virtual clocks fire them in the order of their deadlines once the
+	// simulation advances past them, so that periodic jobs run without actually waiting.
+	NewTimer(Duration) *Timer
+	NewTicker(Duration) *Ticker
+	After(Duration) <-chan stdtime.Time
+	AfterFunc(Duration, func()) *Timer
 }
 
 // All packages should have a global Time set to this by default. This enables simulation testing
@@ -148,12 +153,20 @@ type Time interface {
 //	var Time = sim.UniversalTime
 var UniversalTime Time = &SystemTime{}
 
+// Advance is a yield point of the simulation. Refer to Run.
 func Advance(lo, hi Duration) {
 	UniversalTime.Advance(lo, hi)
+	if simulation.Load() != nil {
+		Yield()
+	}
 }
 
+// Sleep is a yield point of the simulation. Refer to Run.
 func Sleep(duration Duration) {
 	UniversalTime.Sleep(duration)
+	if simulation.Load() != nil {
+		Yield()
+	}
 }
 
 func Monotonic() Moment {
@@ -206,6 +219,10 @@ type VirtualTime struct {
 	JumpStepMin Duration // immutable, inclusive
 	JumpStepMax Duration // immutable, inclusive
 	JumpChance  float32  // immutable
+
+	// timers are pending until Time passes their deadline. Refer to NewTimer.
+	timers        virtualTimers
+	timerSequence uint64
 }
 
 var mysteryTimestamp = Moment(stdtime.Date(2020, stdtime.April, 9, 16, 15, 0, 0, stdtime.UTC).UnixNano())
@@ -247,24 +264,27 @@ func (vtime *VirtualTime) Advance(lo, hi Duration) {
 
 	step := lo
 	if lo != hi {
-		step = lo + Duration(rand.Int64N(int64(hi-lo+1)))
+		step = lo + Duration(Int64N(int64(hi-lo+1)))
 	}
 
 	vtime.Mutex.Lock()
 	vtime.Time = vtime.Time.Advance(step)
-	if shouldSync := vtime.Time.Since(vtime.NTPNext) <= 0; shouldSync {
-		vtime.NTPNext = vtime.NTPNext.Advance(vtime.NTPInterval)
-		vtime.Jump = 0
-	} else {
-		vtime.Jump += jumpStep
-	}
+	vtime.syncNTP(vtime.Time, jumpStep)
+	expired, now := vtime.expireTimers(), vtime.Time
 	vtime.Mutex.Unlock()
+	vtime.fireTimers(expired, now)
 }
 
 func (vtime *VirtualTime) Sleep(duration Duration) {
 	invariant.Always(duration >= 0, "VirtualTime.Sleep argument is a non-negative integer")
 	// This is hardcoded for simplicity. stdtime.Sleep() is inherently inaccurate.
-	vtime.Advance(duration+(100*Microsecond), duration+(1*Millisecond))
+	lo, hi := duration+(100*Microsecond), duration+(1*Millisecond)
+	// Tasks of a simulation sleep concurrently, so the time passes once they're all blocked.
+	if simulation.Load() != nil && UniversalTime == Time(vtime) {
+		vtime.NewTimer(lo + Duration(Int64N(int64(hi-lo+1)))).Wait()
+		return
+	}
+	vtime.Advance(lo, hi)
 }
 
 func (vtime *VirtualTime) Monotonic() (now Moment) {
@@ -273,13 +293,10 @@ func (vtime *VirtualTime) Monotonic() (now Moment) {
 	vtime.Mutex.Lock()
 	vtime.Time = vtime.Time.Advance(vtime.Overhead)
 	now = vtime.Time - vtime.Time%Moment(vtime.MonotonicResolution)
-	if shouldSync := now.Since(vtime.NTPNext) <= 0; shouldSync {
-		vtime.NTPNext = vtime.NTPNext.Advance(vtime.NTPInterval)
-		vtime.Jump = 0
-	} else {
-		vtime.Jump += jumpStep
-	}
+	vtime.syncNTP(now, jumpStep)
+	expired, fired := vtime.expireTimers(), vtime.Time
 	vtime.Mutex.Unlock()
+	vtime.fireTimers(expired, fired)
 	return now
 }
 
@@ -289,22 +306,34 @@ func (vtime *VirtualTime) Realtime() (now Moment) {
 	vtime.Mutex.Lock()
 	vtime.Time = vtime.Time.Advance(vtime.Overhead)
 	now = vtime.Time - vtime.Time%Moment(vtime.RealtimeResolution)
-	if shouldSync := now.Since(vtime.NTPNext) <= 0; shouldSync {
-		vtime.NTPNext = vtime.NTPNext.Advance(vtime.NTPInterval)
-		vtime.Jump = 0
-	} else {
-		vtime.Jump += jumpStep
+	if synced := vtime.syncNTP(now, jumpStep); !synced {
 		now = now.Delta(vtime.Jump)
 	}
+	expired, fired := vtime.expireTimers(), vtime.Time
 	vtime.Mutex.Unlock()
+	vtime.fireTimers(expired, fired)
 	return now
 }
 
+// syncNTP corrects the realtime clock once now reaches NTPNext. Otherwise, the clock jumps by
+// jumpStep. Polls that were missed while Time skipped ahead are merged into one. It must be called
+// while holding the mutex.
+func (vtime *VirtualTime) syncNTP(now Moment, jumpStep Duration) (synced bool) {
+	if now < vtime.NTPNext {
+		vtime.Jump += jumpStep
+		return false
+	}
+	missed := now.Since(vtime.NTPNext) / vtime.NTPInterval
+	vtime.NTPNext = vtime.NTPNext.Advance((missed + 1) * vtime.NTPInterval)
+	vtime.Jump = 0
+	return true
+}
+
 func (vtime *VirtualTime) randJump() Duration {
 	var jumpStep Duration
-	if rand.Float32() < vtime.JumpChance {
-		jumpStep = vtime.JumpStepMin + Duration(rand.Int64N(int64(vtime.JumpStepMax)+1-int64(vtime.JumpStepMin)))
-		if rand.Float32() >= 0.5 {
+	if Float32() < vtime.JumpChance {
+		jumpStep = vtime.JumpStepMin + Duration(Int64N(int64(vtime.JumpStepMax)+1-int64(vtime.JumpStepMin)))
+		if Float32() >= 0.5 {
 			jumpStep *= -1
 		}
 	}
@@ -6,6 +6,7 @@ package sim
 import (
 	"syscall"
 	stdtime "time"
+	"unsafe"
 
 	"github.com/james-orcales/golang_snacks/invariant"
 )
@@ -46,9 +47,12 @@ Reference: https://github.com/tigerbeetle/tigerbeetle/blob/fff8abc12593e72629c95
 */
 func (stime *SystemTime) Monotonic() Moment {
 	var ts syscall.Timespec
-	syscall.ClockGettime(0x7, &ts) // CLOCK_BOOTTIME = 0x7
+	// CLOCK_BOOTTIME = 0x7
+	if _, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, 0x7, uintptr(unsafe.Pointer(&ts)), 0); errno != 0 {
+		panic("CLOCK_BOOTTIME required")
+	}
 	ns := Moment(ts.Sec*Second + ts.Nsec)
-	if ns < t.MonotonicGuard {
+	if ns < stime.MonotonicGuard {
 		panic("a hardware/kernel bug regressed the hardware t")
 	}
 	stime.MonotonicGuard = ns
@@ -0,0 +1,189 @@
+package sim
+
+import (
+	"context"
+	"slices"
+	"sync"
+	stdtime "time"
+)
+
+// WithTimeout is context.WithTimeout on UniversalTime. With a VirtualTime, the deadline passes as
+// the simulation advances, so the deadline is part of the timers that VirtualTime.RunFor skips
+// to. Refer to WithDeadline.
+func WithTimeout(parent context.Context, timeout Duration) (context.Context, context.CancelFunc) {
+	deadline := Realtime().Delta(timeout).StdTime()
+	return withDeadline(parent, deadline, timeout)
+}
+
+// WithDeadline is context.WithDeadline on UniversalTime. The deadline is a Realtime moment, such
+// as sim.Realtime().StdTime().Add(stdtime.Minute), which the Deadline method returns as is.
+//
+// Inside of a simulation, receiving from the Done channel isn't a yield point. Simulated tasks
+// block in Wait instead, or check Err between yield points:
+//
+//	ctx, cancel := sim.WithTimeout(ctx, 5*sim.Second)
+//	defer cancel()
+//	sim.Go(func() { poll(ctx) })
+//	sim.Wait(ctx)
+func WithDeadline(parent context.Context, deadline stdtime.Time) (context.Context, context.CancelFunc) {
+	timeout := Duration(deadline.UnixNano() - int64(Realtime()))
+	return withDeadline(parent, deadline, timeout)
+}
+
+func withDeadline(parent context.Context, deadline stdtime.Time, timeout Duration) (context.Context, context.CancelFunc) {
+	ctx := &deadlineContext{parent: parent, deadline: deadline, done: make(chan struct{})}
+	cancel := func() { ctx.cancel(context.Canceled) }
+	if err := parent.Err(); err != nil {
+		ctx.cancel(err)
+		return ctx, cancel
+	}
+	propagate := func() { ctx.cancel(parent.Err()) }
+	var stopParent func() bool
+	if afterFuncer, ok := parent.(interface{ AfterFunc(func()) func() bool }); ok {
+		stopParent = afterFuncer.AfterFunc(propagate)
+	} else if parent.Done() != nil {
+		stopParent = context.AfterFunc(parent, propagate)
+	}
+	var timer *Timer
+	if current, ok := parent.Deadline(); ok && current.Before(deadline) {
+		// The parent is done first.
+		ctx.deadline = current
+	} else if timeout <= 0 {
+		ctx.cancel(context.DeadlineExceeded)
+	} else {
+		timer = UniversalTime.AfterFunc(timeout, func() {
+			ctx.cancel(context.DeadlineExceeded)
+		})
+	}
+	ctx.mutex.Lock()
+	canceled := ctx.err != nil
+	ctx.stopParent, ctx.timer = stopParent, timer
+	ctx.mutex.Unlock()
+	if canceled {
+		// The parent or the deadline canceled ctx before it could stop them.
+		release(stopParent, timer)
+	}
+	return ctx, cancel
+}
+
+// deadlineContext is canceled by a timer of UniversalTime. Like the contexts of the context
+// package, it is canceled with its parent and reports the error of the parent, and so do the
+// children derived from it with the context package.
+type deadlineContext struct {
+	parent   context.Context
+	deadline stdtime.Time
+
+	mutex sync.Mutex
+	done  chan struct{}
+	err   error
+	// afterFuncs cancel children in the order that they were derived. Refer to AfterFunc.
+	afterFuncs []*func()
+	// stopParent and timer are released once ctx is canceled.
+	stopParent func() bool
+	timer      *Timer
+	// waiters are the tasks in Wait, guarded by the mutex of the simulation.
+	waiters []*task
+}
+
+func (ctx *deadlineContext) Deadline() (stdtime.Time, bool) {
+	return ctx.deadline, true
+}
+
+func (ctx *deadlineContext) Done() <-chan struct{} {
+	return ctx.done
+}
+
+func (ctx *deadlineContext) Err() error {
+	ctx.mutex.Lock()
+	defer ctx.mutex.Unlock()
+	return ctx.err
+}
+
+func (ctx *deadlineContext) Value(key any) any {
+	return ctx.parent.Value(key)
+}
+
+// AfterFunc calls fn once ctx is canceled, in the task that cancels it. The context package
+// derives children through it instead of watching Done from a goroutine, so the children of a
+// simulation are canceled at the same point of every replay.
+func (ctx *deadlineContext) AfterFunc(fn func()) (stop func() bool) {
+	ctx.mutex.Lock()
+	if ctx.err != nil {
+		ctx.mutex.Unlock()
+		// The caller may hold locks that fn takes, like context.AfterFunc. Inside of a simulation,
+		// fn is a task so that it runs at the same point of every replay.
+		Go(fn)
+		return func() bool { return false }
+	}
+	defer ctx.mutex.Unlock()
+	entry := &fn
+	ctx.afterFuncs = append(ctx.afterFuncs, entry)
+	return func() bool {
+		ctx.mutex.Lock()
+		defer ctx.mutex.Unlock()
+		i := slices.Index(ctx.afterFuncs, entry)
+		if i < 0 {
+			return false
+		}
+		ctx.afterFuncs = slices.Delete(ctx.afterFuncs, i, i+1)
+		return true
+	}
+}
+
+func (ctx *deadlineContext) cancel(err error) {
+	ctx.mutex.Lock()
+	if ctx.err != nil {
+		ctx.mutex.Unlock()
+		return
+	}
+	ctx.err = err
+	close(ctx.done)
+	afterFuncs, stopParent, timer := ctx.afterFuncs, ctx.stopParent, ctx.timer
+	ctx.afterFuncs = nil
+	ctx.mutex.Unlock()
+
+	release(stopParent, timer)
+	for _, fn := range afterFuncs {
+		(*fn)()
+	}
+	if s := simulation.Load(); s != nil {
+		s.mutex.Lock()
+		s.wake(&ctx.waiters)
+		s.mutex.Unlock()
+	}
+}
+
+// release stops watching the parent and the deadline of a canceled context so that its timer
+// doesn't wake the simulation.
+func release(stopParent func() bool, timer *Timer) {
+	if stopParent != nil {
+		stopParent()
+	}
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// Wait blocks until ctx is done and returns its error. Inside of a simulation on a VirtualTime, it
+// is a yield point for the contexts of WithTimeout and WithDeadline that parks the task until
+// they are canceled, so that the simulation skips straight to their deadline once every task is
+// blocked. Other contexts are waited on by receiving from Done, which isn't a yield point.
+func Wait(ctx context.Context) error {
+	s := simulation.Load()
+	_, virtual := UniversalTime.(*VirtualTime)
+	deadlineCtx, ok := ctx.(*deadlineContext)
+	if s == nil || !virtual || !ok {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	Yield()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for {
+		if err := deadlineCtx.Err(); err != nil {
+			return err
+		}
+		s.park(&deadlineCtx.waiters)
+		s.mutex.Lock()
+	}
+}
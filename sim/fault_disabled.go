@@ -37,7 +37,7 @@ var (
 	DefaultLatencyMax  Duration = 0
 
 	FaultChanceMemorySpike float32 = 0
-	FaultMemorySpikeBytes  float32 = 0
+	FaultMemorySpikeBytes  int     = 0
 )
 
 func Panic() {
@@ -53,9 +53,11 @@ func AssertionFailureN(chance float32) {
 }
 
 func Bool() bool {
+	return false
 }
 
 func BoolN(chance float32) bool {
+	return false
 }
 
 func Err(err *error) error {
@@ -99,5 +101,5 @@ func LatencyN(chance float32, lo, hi Duration) {
 func MemorySpike(release <-chan struct{}) {
 }
 
-func MemorySpikeN(chance, n int, release <-chan struct{}) {
+func MemorySpikeN(chance float32, n int, release <-chan struct{}) {
 }
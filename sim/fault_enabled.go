@@ -4,7 +4,7 @@ package sim
 
 import (
 	"errors"
-	"math/rand/v2"
+	"reflect"
 
 	"github.com/james-orcales/golang_snacks/invariant"
 )
@@ -53,7 +53,7 @@ func Panic() {
 }
 
 func PanicN(chance float32) {
-	if rand.Float32() < chance {
+	if Float32() < chance {
 		panic(FaultErrorPrefix + "Panic")
 	}
 }
@@ -62,8 +62,14 @@ func AssertionFailure() {
 	AssertionFailureN(FaultChanceAssertionFailure)
 }
 
+// packagePath is the import path that the FailurePolicy matches the injected failures against.
+var packagePath = reflect.TypeOf(Moment(0)).PkgPath()
+
+// AssertionFailureN doesn't inject failures that would exit the process.
 func AssertionFailureN(chance float32) {
-	if !invariant.AssertionFailureIsFatal && rand.Float32() < chance {
+	mode := invariant.CurrentFailurePolicy().ModeFor(packagePath, "Ensure")
+	isFatal := mode == invariant.FailureExit || (mode == invariant.FailurePanic && invariant.AssertionFailureIsFatal)
+	if !isFatal && Float32() < chance {
 		invariant.Ensure(false, "Fault Injected")
 	}
 }
@@ -73,7 +79,7 @@ func Bool() bool {
 }
 
 func BoolN(chance float32) bool {
-	return rand.Float32() < chance
+	return Float32() < chance
 }
 
 // Err randomly returns an error based on FaultChanceGeneric.
@@ -96,7 +102,7 @@ func Err(err *error) error {
 }
 
 func ErrN(chance float32, err *error) error {
-	if err == nil && rand.Float32() < chance {
+	if err == nil && Float32() < chance {
 		*err = errors.New(FaultErrorPrefix + "Generic error")
 	}
 	return *err
@@ -107,7 +113,7 @@ func IOErr(err *error) error {
 }
 
 func IOErrN(chance float32, err *error) error {
-	if err == nil && rand.Float32() < chance {
+	if err == nil && Float32() < chance {
 		*err = errors.New(FaultErrorPrefix + "IO error (Generic)")
 	}
 	return *err
@@ -118,7 +124,7 @@ func IODiskErr(err *error) error {
 }
 
 func IODiskErrN(chance float32, err *error) error {
-	if err == nil && rand.Float32() < chance {
+	if err == nil && Float32() < chance {
 		*err = errors.New(FaultErrorPrefix + "IO error (Disk)")
 	}
 	return *err
@@ -130,7 +136,7 @@ func IONetworkErr(err *error) error {
 }
 
 func IONetworkErrN(chance float32, err *error) error {
-	if err == nil && rand.Float32() < chance {
+	if err == nil && Float32() < chance {
 		*err = errors.New(FaultErrorPrefix + "IO error (Network)")
 	}
 	return *err
@@ -141,7 +147,7 @@ func Latency() {
 }
 
 func LatencyN(chance float32, lo, hi Duration) {
-	if rand.Float32() < chance {
+	if Float32() < chance {
 		UniversalTime.Advance(lo, hi)
 	}
 }
@@ -159,7 +165,7 @@ func MemorySpike(release <-chan struct{}) {
 //
 // TODO: Verify if this gets optimized away
 func MemorySpikeN(chance float32, n int, release <-chan struct{}) {
-	if rand.Float32() < chance {
+	if Float32() < chance {
 		garbage := make([]byte, n)
 		<-release
 		garbage[0] = 42
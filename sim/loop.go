@@ -0,0 +1,119 @@
+package sim
+
+import (
+	"iter"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+)
+
+// UntilDeadline is invariant.Until bounded by the Monotonic time of UniversalTime instead of a
+// number of iterations. Under a VirtualTime, the deadline passes in simulated time.
+//
+//	for range sim.UntilDeadline(30 * sim.Second) {
+//		if ready() {
+//			break
+//		}
+//		sim.Sleep(100 * sim.Millisecond)
+//	}
+func UntilDeadline(timeout Duration) iter.Seq[int] {
+	return invariant.UntilDeadline(timeout, func() Duration {
+		return Duration(Monotonic())
+	})
+}
+
+// Backoff spaces out the attempts of Retry. The delay starts at Initial and is multiplied by
+// Multiplier after every attempt until it reaches Max.
+type Backoff struct {
+	Initial Duration
+	Max     Duration
+	// Multiplier defaults to 2.
+	Multiplier float64
+	// Jitter randomizes every delay by up to this fraction of it so that clients that failed
+	// together don't retry together.
+	Jitter float64
+}
+
+// DefaultBackoff suits calls over the network.
+var DefaultBackoff = Backoff{
+	Initial:    100 * Millisecond,
+	Max:        10 * Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Delay returns the delay before the given attempt, which starts at 0. The first attempt has no
+// delay.
+func (backoff Backoff) Delay(attempt int) Duration {
+	invariant.Always(attempt >= 0, "Backoff attempts start at 0")
+	invariant.Always(0 < backoff.Initial && backoff.Initial <= backoff.Max, "Backoff delays are positive and bounded")
+	invariant.Always(0 <= backoff.Jitter && backoff.Jitter <= 1, "Backoff.Jitter is 0.0-1.0")
+	if attempt == 0 {
+		return 0
+	}
+	multiplier := backoff.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	invariant.Always(multiplier >= 1, "Backoff delays never shrink")
+	delay := float64(backoff.Initial)
+	for range attempt - 1 {
+		if delay >= float64(backoff.Max) {
+			break
+		}
+		delay *= multiplier
+	}
+	delay = min(delay, float64(backoff.Max))
+	if backoff.Jitter > 0 {
+		delay += delay * backoff.Jitter * (2*Float64() - 1)
+	}
+	return min(max(Duration(delay), 0), backoff.Max)
+}
+
+// Retry yields up to attempts attempt numbers, starting at 0, and sleeps on UniversalTime for
+// backoff.Delay between them. Break out of the loop once an attempt succeeds. Unlike
+// invariant.Until, running out of attempts isn't a bug since retried operations are expected to
+// fail, so the caller handles it.
+//
+//	var err error
+//	for range sim.Retry(5, sim.DefaultBackoff) {
+//		if err = send(msg); err == nil {
+//			break
+//		}
+//	}
+//	if err != nil {
+//		return fmt.Errorf("sending after 5 attempts: %w", err)
+//	}
+func Retry(attempts int, backoff Backoff) iter.Seq[int] {
+	invariant.Always(attempts > 0, "Retry makes at least one attempt")
+	return func(yield func(int) bool) {
+		for attempt := range attempts {
+			if delay := backoff.Delay(attempt); delay > 0 {
+				Sleep(delay)
+			}
+			if !yield(attempt) {
+				return
+			}
+		}
+	}
+}
+
+// RetryUntilDeadline is Retry bounded by the Monotonic time of UniversalTime instead of a number
+// of attempts. The last delay is cut short by the deadline.
+func RetryUntilDeadline(timeout Duration, backoff Backoff) iter.Seq[int] {
+	invariant.Always(timeout > 0, "RetryUntilDeadline has a positive timeout")
+	return func(yield func(int) bool) {
+		deadline := Monotonic().Delta(timeout)
+		for attempt := 0; true; attempt++ {
+			now := Monotonic()
+			if now >= deadline {
+				return
+			}
+			if delay := min(backoff.Delay(attempt), deadline.Since(now)); delay > 0 {
+				Sleep(delay)
+			}
+			if !yield(attempt) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package sim
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"sync"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+)
+
+// SeedEnv overrides the seed of the simulation. It is printed when a simulation fails so that the
+// run can be replayed:
+//
+//	SIM_SEED=1234 go test ./...
+const SeedEnv = "SIM_SEED"
+
+var (
+	// random is the source of every random decision of the simulation: the virtual clocks,
+	// the faults and the scheduler. Refer to Seed.
+	random      *rand.Rand
+	randomSeed  uint64
+	randomOnce  sync.Once
+	randomMutex sync.Mutex
+
+	printSeedOnce sync.Once
+)
+
+// init prints the seed on the first assertion failure during a simulation, that is inside of Run
+// or on a VirtualTime. Programs that only import the package are left alone.
+func init() {
+	previous := invariant.AssertionFailureHook
+	invariant.AssertionFailureHook = func(msg string) {
+		_, virtual := UniversalTime.(*VirtualTime)
+		if simulation.Load() != nil || virtual {
+			randomMutex.Lock()
+			// The hook doesn't resolve the seed, which would read SeedEnv.
+			seed, seeded := randomSeed, random != nil
+			randomMutex.Unlock()
+			if seeded {
+				printSeedOnce.Do(func() {
+					fmt.Fprintf(os.Stderr, "Replay the simulation with %s=%d\n", SeedEnv, seed)
+				})
+			}
+		}
+		previous(msg)
+	}
+}
+
+// Seed returns the seed of the simulation. It is taken from SeedEnv, then the VCS revision of the
+// binary so that a commit always simulates the same run. Binaries without a revision, such as
+// tests, get a random seed. The seed is chosen on first use.
+func Seed() uint64 {
+	randomOnce.Do(func() {
+		setSeed(defaultSeed())
+	})
+	randomMutex.Lock()
+	defer randomMutex.Unlock()
+	return randomSeed
+}
+
+// SetSeed restarts the random source of the simulation from seed. Call it before the simulation
+// starts to replay it.
+func SetSeed(seed uint64) {
+	// The default seed is only resolved if SetSeed wasn't called first.
+	randomOnce.Do(func() {})
+	setSeed(seed)
+}
+
+func setSeed(seed uint64) {
+	randomMutex.Lock()
+	defer randomMutex.Unlock()
+	randomSeed = seed
+	random = rand.New(rand.NewPCG(seed, seed))
+}
+
+func defaultSeed() uint64 {
+	if value := os.Getenv(SeedEnv); value != "" {
+		seed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			panic(fmt.Sprintf("sim: the seed %q is not an unsigned integer", value))
+		}
+		return seed
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				hash := fnv.New64a()
+				hash.Write([]byte(setting.Value))
+				return hash.Sum64()
+			}
+		}
+	}
+	return rand.Uint64()
+}
+
+// SeedReporter is the subset of testing.TB that ReportSeed needs.
+type SeedReporter interface {
+	Cleanup(func())
+	Failed() bool
+	Logf(format string, args ...any)
+}
+
+// ReportSeed logs the seed when t fails. Assertion failures print it on their own.
+//
+//	func TestCluster(t *testing.T) {
+//		sim.ReportSeed(t)
+//		...
+//	}
+func ReportSeed(t SeedReporter) {
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("Replay the simulation with %s=%d", SeedEnv, Seed())
+		}
+	})
+}
+
+// withRandom calls fn with the random source of the simulation. Concurrent goroutines draw in the
+// order that they lock it, which is only reproducible between the tasks of Run.
+func withRandom[T any](fn func(random *rand.Rand) T) T {
+	Seed()
+	randomMutex.Lock()
+	defer randomMutex.Unlock()
+	return fn(random)
+}
+
+// Float32 returns a number in [0.0, 1.0) from the random source of the simulation.
+func Float32() float32 {
+	return withRandom((*rand.Rand).Float32)
+}
+
+// Float64 returns a number in [0.0, 1.0) from the random source of the simulation.
+func Float64() float64 {
+	return withRandom((*rand.Rand).Float64)
+}
+
+// Int64N returns a number in [0, n) from the random source of the simulation.
+func Int64N(n int64) int64 {
+	return withRandom(func(random *rand.Rand) int64 {
+		return random.Int64N(n)
+	})
+}
+
+// IntN returns a number in [0, n) from the random source of the simulation.
+func IntN(n int) int {
+	return withRandom(func(random *rand.Rand) int {
+		return random.IntN(n)
+	})
+}
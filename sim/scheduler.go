@@ -0,0 +1,380 @@
+package sim
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	stdtime "time"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+)
+
+// scheduler runs the tasks of a simulation one at a time. Every task is a goroutine that waits for
+// its turn on its resume channel. The running task hands the turn over at yield points by
+// resuming a runnable task picked with the random source of the simulation, so a seed always
+// interleaves the tasks the same way.
+type scheduler struct {
+	mutex    sync.Mutex
+	runnable []*task
+	// idle are the tasks in VirtualTime.RunUntilIdle. They are resumed once no other task is
+	// runnable.
+	idle []*task
+	live int
+	done chan struct{}
+	// current is the only task that runs.
+	current *task
+	// turns counts the tasks resumed so that watch can tell that the current one hasn't yielded.
+	turns uint64
+	// failure is the first panic of a task or of watch, which Run panics with.
+	failure any
+	failed  bool
+}
+
+type task struct {
+	// resume is buffered so that a task can pick itself without blocking.
+	resume chan struct{}
+	// goroutine runs the task. It is zero until the task starts.
+	goroutine invariant.Goroutine
+}
+
+// simulation is the scheduler of the running simulation, if any.
+var simulation atomic.Pointer[scheduler]
+
+// Run simulates main and every task that it spawns with Go until they all return. Tasks only
+// switch at yield points: Yield, Sleep, Advance, the operations of Chan and the Wait of timers.
+// Everything else runs uninterrupted, so the order of events only depends on the seed. Combined
+// with a VirtualTime, a whole system replays identically:
+//
+//	sim.SetSeed(seed)
+//	sim.UniversalTime = sim.NewVirtualTime(nil)
+//	sim.Run(func() {
+//		requests := sim.NewChan[Request](0)
+//		sim.Go(func() { server(requests) })
+//		client(requests)
+//	})
+//
+// With a VirtualTime, blocked tasks don't wait for the time to pass. Refer to VirtualTime.RunFor.
+//
+// Run panics once every task is blocked at a yield point, or with the panic of a task. Blocking on
+// anything other than a yield point, such as a receive from Timer.C or After, a sync.Mutex held by
+// another task or a Go channel, deadlocks the simulation since the other tasks never get their
+// turn. With a VirtualTime, whose timers only fire at yield points, Run panics once the current
+// task stays blocked like this for a second. Use Timer.Wait, Ticker.Wait and Sleep instead. Yield
+// points must only be reached by tasks, not by goroutines spawned with the go statement. Only one
+// simulation runs at a time.
+func Run(main func()) {
+	s := &scheduler{done: make(chan struct{})}
+	invariant.Always(simulation.CompareAndSwap(nil, s), "Only one simulation runs at a time")
+	defer simulation.Store(nil)
+	s.mutex.Lock()
+	s.spawn(main)
+	s.switchTask()
+	go s.watch()
+	<-s.done
+	s.mutex.Lock()
+	failed, failure := s.failed, s.failure
+	s.mutex.Unlock()
+	if failed {
+		panic(failure)
+	}
+}
+
+// Go spawns fn as a task of the running simulation. Outside of a simulation, it is a go statement.
+func Go(fn func()) {
+	s := simulation.Load()
+	if s == nil {
+		go fn()
+		return
+	}
+	s.mutex.Lock()
+	s.spawn(fn)
+	s.mutex.Unlock()
+}
+
+// Yield lets the simulation switch to another task. Outside of a simulation, it is
+// runtime.Gosched.
+func Yield() {
+	s := simulation.Load()
+	if s == nil {
+		runtime.Gosched()
+		return
+	}
+	s.mutex.Lock()
+	t := s.current
+	s.runnable = append(s.runnable, t)
+	s.switchTask()
+	<-t.resume
+}
+
+// spawn must be called while holding the mutex.
+func (s *scheduler) spawn(fn func()) {
+	t := &task{resume: make(chan struct{}, 1)}
+	s.live++
+	s.runnable = append(s.runnable, t)
+	go func() {
+		goroutine := invariant.CurrentGoroutine()
+		s.mutex.Lock()
+		t.goroutine = goroutine
+		s.mutex.Unlock()
+		<-t.resume
+		defer func() {
+			if failure := recover(); failure != nil {
+				s.fail(failure)
+			}
+		}()
+		fn()
+		s.exit()
+	}()
+}
+
+func (s *scheduler) exit() {
+	s.mutex.Lock()
+	s.live--
+	s.switchTask()
+}
+
+// fail stops the simulation so that Run panics with failure. The other tasks stay blocked.
+func (s *scheduler) fail(failure any) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.failed {
+		s.failed, s.failure = true, failure
+	}
+	s.stop()
+}
+
+// stop lets Run return. It must be called while holding the mutex.
+func (s *scheduler) stop() {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+}
+
+// park blocks the current task until another task wakes the waiters. It must be called while
+// holding the mutex, which it releases.
+func (s *scheduler) park(waiters *[]*task) {
+	t := s.current
+	*waiters = append(*waiters, t)
+	s.switchTask()
+	<-t.resume
+}
+
+// wake makes the waiters runnable. It must be called while holding the mutex.
+func (s *scheduler) wake(waiters *[]*task) {
+	s.runnable = append(s.runnable, *waiters...)
+	*waiters = (*waiters)[:0]
+}
+
+// switchTask resumes a random runnable task. Once every task is blocked, the tasks in
+// VirtualTime.RunUntilIdle are resumed, or else UniversalTime jumps to the next timer that wakes a
+// task. It must be called while holding the mutex, which it releases.
+func (s *scheduler) switchTask() {
+	if len(s.runnable) == 0 {
+		s.wake(&s.idle)
+	}
+	if vtime, ok := UniversalTime.(*VirtualTime); ok && len(s.runnable) == 0 {
+		vtime.advanceIdle(s)
+	}
+	if len(s.runnable) == 0 {
+		s.current = nil
+		live := s.live
+		if live == 0 {
+			s.stop()
+		}
+		s.mutex.Unlock()
+		if live > 0 {
+			panic("sim: deadlock! Every simulated task is blocked")
+		}
+		return
+	}
+	i := IntN(len(s.runnable))
+	t := s.runnable[i]
+	s.runnable = append(s.runnable[:i], s.runnable[i+1:]...)
+	s.current = t
+	s.turns++
+	s.mutex.Unlock()
+	t.resume <- struct{}{}
+}
+
+// stallTimeout is how long the current task may block outside of a yield point under VirtualTime.
+const stallTimeout = stdtime.Second
+
+// watch fails s once the current task of s blocks outside of a yield point for stallTimeout while
+// UniversalTime is a VirtualTime. The timers of SystemTime fire on their own, so blocking on them
+// only delays the other tasks.
+func (s *scheduler) watch() {
+	defer func() {
+		if failure := recover(); failure != nil {
+			s.fail(failure)
+		}
+	}()
+	ticker := stdtime.NewTicker(stallTimeout / 4)
+	defer ticker.Stop()
+	var (
+		blockedTurn  uint64
+		blockedSince stdtime.Time
+	)
+	for {
+		var now stdtime.Time
+		select {
+		case <-s.done:
+			return
+		case now = <-ticker.C:
+		}
+		s.mutex.Lock()
+		turn, goroutine := s.turns, invariant.Goroutine(0)
+		if s.current != nil {
+			goroutine = s.current.goroutine
+		}
+		s.mutex.Unlock()
+		stack := ""
+		if _, ok := UniversalTime.(*VirtualTime); ok && goroutine != 0 {
+			stack = blockedStack(goroutine)
+		}
+		switch {
+		case stack == "":
+			blockedSince = stdtime.Time{}
+		case blockedSince.IsZero() || turn != blockedTurn:
+			blockedTurn, blockedSince = turn, now
+		case now.Sub(blockedSince) >= stallTimeout:
+			panic(fmt.Sprintf("sim: a task is blocked outside of a yield point, such as a receive from Timer.C or After. Use Timer.Wait, Ticker.Wait or Sleep instead.\n\n%s", stack))
+		}
+	}
+}
+
+// blockedStates are the states of stack traces that only end once another goroutine acts.
+var blockedStates = [...]string{"chan receive", "chan send", "select", "sync.", "semacquire"}
+
+// blockedStack returns the stack trace of goroutine if it is blocked, or else an empty string.
+func blockedStack(goroutine invariant.Goroutine) string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	header := []byte("goroutine " + strconv.FormatUint(uint64(goroutine), 10) + " [")
+	start := bytes.Index(buf, header)
+	if start < 0 {
+		return ""
+	}
+	trace := buf[start:]
+	if end := bytes.Index(trace, []byte("\n\n")); end >= 0 {
+		trace = trace[:end]
+	}
+	state := trace[len(header):]
+	for _, blocked := range blockedStates {
+		if bytes.HasPrefix(state, []byte(blocked)) {
+			return string(trace)
+		}
+	}
+	return ""
+}
+
+// Chan is a channel that is a yield point of the simulation. Tasks that would block on it are
+// parked so that the others run. Outside of a simulation, it is a Go channel. It must be created
+// inside the simulation that uses it.
+type Chan[T any] struct {
+	// ch is the Go channel outside of a simulation.
+	ch chan T
+
+	capacity int
+	buffer   []T
+	closed   bool
+	// Unbuffered sends wait until their value is received.
+	sent, received uint64
+	senders        []*task
+	receivers      []*task
+}
+
+func NewChan[T any](capacity int) *Chan[T] {
+	invariant.Always(capacity >= 0, "Chan capacity is non-negative")
+	if simulation.Load() == nil {
+		return &Chan[T]{ch: make(chan T, capacity)}
+	}
+	return &Chan[T]{capacity: capacity}
+}
+
+func (c *Chan[T]) Send(value T) {
+	if c.ch != nil {
+		c.ch <- value
+		return
+	}
+	Yield()
+	s := simulation.Load()
+	s.mutex.Lock()
+	for !c.closed && len(c.buffer) >= max(c.capacity, 1) {
+		s.park(&c.senders)
+		s.mutex.Lock()
+	}
+	if c.closed {
+		s.mutex.Unlock()
+		panic("sim: send on closed Chan")
+	}
+	c.buffer = append(c.buffer, value)
+	c.sent++
+	sent := c.sent
+	s.wake(&c.receivers)
+	for c.capacity == 0 && c.received < sent && !c.closed {
+		s.park(&c.senders)
+		s.mutex.Lock()
+	}
+	if c.capacity == 0 && c.received < sent {
+		// Like a Go channel, closing it panics the senders that are still waiting.
+		s.mutex.Unlock()
+		panic("sim: send on closed Chan")
+	}
+	s.mutex.Unlock()
+}
+
+// Recv returns false once the channel is closed and drained.
+func (c *Chan[T]) Recv() (value T, ok bool) {
+	if c.ch != nil {
+		value, ok = <-c.ch
+		return value, ok
+	}
+	Yield()
+	s := simulation.Load()
+	s.mutex.Lock()
+	for len(c.buffer) == 0 && !c.closed {
+		s.park(&c.receivers)
+		s.mutex.Lock()
+	}
+	if len(c.buffer) > 0 {
+		value, ok = c.buffer[0], true
+		var zero T
+		c.buffer[0] = zero
+		c.buffer = c.buffer[1:]
+		c.received++
+		s.wake(&c.senders)
+	}
+	s.mutex.Unlock()
+	return value, ok
+}
+
+func (c *Chan[T]) Close() {
+	if c.ch != nil {
+		close(c.ch)
+		return
+	}
+	s := simulation.Load()
+	s.mutex.Lock()
+	invariant.Always(!c.closed, "Chan is closed once")
+	c.closed = true
+	if c.capacity == 0 {
+		// The values of waiting senders are never received.
+		clear(c.buffer)
+		c.buffer = c.buffer[:0]
+	}
+	s.wake(&c.receivers)
+	s.wake(&c.senders)
+	s.mutex.Unlock()
+}
@@ -0,0 +1,328 @@
+package sim_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/james-orcales/golang_snacks/sim"
+)
+
+func TestSeedReplaysVirtualTime(t *testing.T) {
+	sim.ReportSeed(t)
+	// The default seed is resolved on first use.
+	sim.Seed()
+	run := func(seed uint64) []sim.Moment {
+		sim.SetSeed(seed)
+		vtime := sim.NewVirtualTime(nil)
+		moments := make([]sim.Moment, 0, 1000)
+		for range 1000 {
+			vtime.Advance(0, sim.Second)
+			moments = append(moments, vtime.Realtime())
+		}
+		return moments
+	}
+	first := run(1234)
+	if !slices.Equal(first, run(1234)) {
+		t.Fatal("The same seed simulated different clocks")
+	}
+	if slices.Equal(first, run(4321)) {
+		t.Fatal("Different seeds simulated the same clock")
+	}
+	if sim.Seed() != 4321 {
+		t.Fatalf("expected the seed that was set. got %d", sim.Seed())
+	}
+}
+
+func TestRunReplaysInterleavings(t *testing.T) {
+	sim.ReportSeed(t)
+	run := func(seed uint64) []string {
+		sim.SetSeed(seed)
+		var events []string
+		sim.Run(func() {
+			requests := sim.NewChan[int](0)
+			responses := sim.NewChan[string](2)
+			for worker := range 3 {
+				sim.Go(func() {
+					for request, ok := requests.Recv(); ok; request, ok = requests.Recv() {
+						events = append(events, fmt.Sprintf("worker %d got %d", worker, request))
+						responses.Send(fmt.Sprint(request * request))
+					}
+				})
+			}
+			sim.Go(func() {
+				for request := range 10 {
+					requests.Send(request)
+				}
+				requests.Close()
+			})
+			for range 10 {
+				response, _ := responses.Recv()
+				events = append(events, "response "+response)
+			}
+		})
+		return events
+	}
+	first := run(1234)
+	if len(first) != 20 {
+		t.Fatalf("expected every request and response. got %q", first)
+	}
+	if !slices.Equal(first, run(1234)) {
+		t.Fatal("The same seed interleaved the tasks differently")
+	}
+	if slices.Equal(first, run(4321)) {
+		t.Fatal("Different seeds interleaved the tasks the same way")
+	}
+}
+
+// runPanic returns the value that sim.Run panics with.
+func runPanic(main func()) (failure any) {
+	defer func() { failure = recover() }()
+	sim.Run(main)
+	return nil
+}
+
+func TestRunPanics(t *testing.T) {
+	sim.ReportSeed(t)
+	sendPanic := runPanic(func() {
+		unbuffered := sim.NewChan[int](0)
+		sim.Go(func() { unbuffered.Send(1) })
+		for range 100 {
+			sim.Yield()
+		}
+		unbuffered.Close()
+		if value, ok := unbuffered.Recv(); ok {
+			t.Errorf("Received %d from the sender that closing the Chan panicked", value)
+		}
+	})
+	if sendPanic != "sim: send on closed Chan" {
+		t.Fatalf("expected the waiting sender to panic. got %v", sendPanic)
+	}
+
+	deadlockPanic := runPanic(func() {
+		sim.NewChan[int](0).Recv()
+	})
+	if deadlockPanic != "sim: deadlock! Every simulated task is blocked" {
+		t.Fatalf("expected a deadlock to panic. got %v", deadlockPanic)
+	}
+
+	if failure := runPanic(func() {}); failure != nil {
+		t.Fatalf("expected a simulation after a panic to run. got %v", failure)
+	}
+}
+
+func TestVirtualTimers(t *testing.T) {
+	sim.ReportSeed(t)
+	sim.SetSeed(1)
+	vtime := sim.NewVirtualTime(nil)
+	vtime.JumpChance = 0
+	fired := func(c <-chan time.Time) bool {
+		select {
+		case <-c:
+			return true
+		default:
+			return false
+		}
+	}
+
+	first, second, stopped := vtime.NewTimer(sim.Second), vtime.NewTimer(2*sim.Second), vtime.NewTimer(sim.Second)
+	ticker := vtime.NewTicker(sim.Second)
+	done := make(chan struct{})
+	vtime.AfterFunc(2*sim.Second, func() { close(done) })
+	if !stopped.Stop() {
+		t.Fatal("Stopping a pending timer reports that it was pending")
+	}
+
+	vtime.Advance(1500*sim.Millisecond, 1500*sim.Millisecond)
+	if !fired(first.C) || fired(second.C) || fired(stopped.C) || !fired(ticker.C) {
+		t.Fatal("Only the timers whose deadline passed fired")
+	}
+	vtime.Advance(10*sim.Second, 10*sim.Second)
+	if !fired(second.C) || !fired(ticker.C) || fired(ticker.C) {
+		t.Fatal("Missed ticks are dropped like stdtime.Ticker")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("AfterFunc didn't run")
+	}
+	if first.Stop() || first.Reset(sim.Second) {
+		t.Fatal("A fired timer isn't pending")
+	}
+	ticker.Stop()
+	vtime.Advance(2*sim.Second, 2*sim.Second)
+	if !fired(first.C) || fired(ticker.C) {
+		t.Fatal("Reset timers fire again and stopped tickers don't")
+	}
+}
+
+func TestRunForSkipsToDeadlines(t *testing.T) {
+	sim.ReportSeed(t)
+	sim.SetSeed(1)
+	vtime := sim.NewVirtualTime(nil)
+	vtime.JumpChance = 0
+	previous := sim.UniversalTime
+	sim.UniversalTime = vtime
+	t.Cleanup(func() { sim.UniversalTime = previous })
+
+	start, wallStart := vtime.Time, time.Now()
+	var hourly, daily, dailyWithinWeek, reminders int
+	sim.Run(func() {
+		ticker := sim.NewTicker(sim.Hour)
+		sim.Go(func() {
+			for _, ok := ticker.Wait(); ok; _, ok = ticker.Wait() {
+				hourly++
+			}
+		})
+		sim.Go(func() {
+			for range 7 {
+				sim.Sleep(sim.Day)
+				daily++
+			}
+		})
+		sim.AfterFunc(36*sim.Hour, func() { reminders++ })
+		vtime.RunFor(sim.Week)
+		dailyWithinWeek = daily
+		ticker.Stop()
+	})
+	if hourly != 7*24 || dailyWithinWeek != 6 || reminders != 1 {
+		t.Fatalf("expected a week of jobs. got hourly=%d daily=%d reminders=%d", hourly, dailyWithinWeek, reminders)
+	}
+	// The last daily job ran after the driver returned since blocked tasks skip to their timers.
+	if elapsed := vtime.Time - start; daily != 7 || elapsed <= sim.Week {
+		t.Fatalf("expected the remaining sleep to be simulated. got %d", elapsed)
+	}
+	if wall := time.Since(wallStart); wall > 5*time.Second {
+		t.Fatalf("expected a simulated week to take milliseconds. took %s", wall)
+	}
+
+	before := vtime.Time
+	timer := vtime.NewTimer(sim.Minute)
+	if !vtime.AdvanceToNext() || vtime.Time != before+sim.Minute {
+		t.Fatal("AdvanceToNext jumps to the pending deadline")
+	}
+	if _, ok := timer.Wait(); !ok || vtime.AdvanceToNext() {
+		t.Fatal("AdvanceToNext fires the timer and has nothing left to advance to")
+	}
+}
+
+func TestRunReportsBlockingReceives(t *testing.T) {
+	sim.ReportSeed(t)
+	previous := sim.UniversalTime
+	sim.UniversalTime = sim.NewVirtualTime(nil)
+	t.Cleanup(func() { sim.UniversalTime = previous })
+	failure := runPanic(func() {
+		after := sim.After(sim.Second)
+		// The task stays blocked after Run panics. Yielding orders its read of UniversalTime
+		// before the cleanup.
+		sim.Yield()
+		<-after
+	})
+	if msg, _ := failure.(string); !strings.Contains(msg, "sim: a task is blocked outside of a yield point") {
+		t.Fatalf("expected the receive from After to panic. got %v", failure)
+	}
+}
+
+func TestContextDeadlines(t *testing.T) {
+	sim.ReportSeed(t)
+	system, cancel := sim.WithTimeout(context.Background(), 10*sim.Millisecond)
+	defer cancel()
+	select {
+	case <-system.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("The deadline of SystemTime didn't pass")
+	}
+	if system.Err() != context.DeadlineExceeded {
+		t.Fatalf("expected an exceeded deadline. got %v", system.Err())
+	}
+
+	sim.SetSeed(1)
+	vtime := sim.NewVirtualTime(nil)
+	vtime.JumpChance = 0
+	previous := sim.UniversalTime
+	sim.UniversalTime = vtime
+	t.Cleanup(func() { sim.UniversalTime = previous })
+
+	var polls int
+	var err, childErr error
+	start := vtime.Time
+	sim.Run(func() {
+		ctx, cancel := sim.WithTimeout(context.Background(), 5*sim.Second)
+		defer cancel()
+		child, cancelChild := sim.WithTimeout(ctx, sim.Hour)
+		defer cancelChild()
+		for ctx.Err() == nil {
+			polls++
+			sim.Sleep(sim.Second)
+		}
+		err, childErr = ctx.Err(), child.Err()
+	})
+	if polls != 5 || err != context.DeadlineExceeded || childErr != context.DeadlineExceeded {
+		t.Fatalf("expected the virtual deadline to pass after 5 polls. got %d polls, %v and %v", polls, err, childErr)
+	}
+	if elapsed := vtime.Time - start; elapsed < 5*sim.Second || elapsed > 6*sim.Second {
+		t.Fatalf("expected the deadline to pass in virtual time. got %d", elapsed)
+	}
+
+	deadline := vtime.Realtime().StdTime().Add(time.Minute)
+	ctx, cancel := sim.WithDeadline(context.Background(), deadline)
+	if got, ok := ctx.Deadline(); !ok || !got.Equal(deadline) {
+		t.Fatalf("expected the deadline %s. got %s", deadline, got)
+	}
+	cancel()
+	if ctx.Err() != context.Canceled {
+		t.Fatalf("expected a canceled context. got %v", ctx.Err())
+	}
+	expired, cancel := sim.WithTimeout(context.Background(), -sim.Second)
+	defer cancel()
+	if expired.Err() != context.DeadlineExceeded || context.Cause(expired) != context.DeadlineExceeded {
+		t.Fatal("A deadline in the past is exceeded immediately")
+	}
+}
+
+func TestWaitOnContexts(t *testing.T) {
+	sim.ReportSeed(t)
+	sim.SetSeed(1)
+	vtime := sim.NewVirtualTime(nil)
+	vtime.JumpChance = 0
+	previous := sim.UniversalTime
+	sim.UniversalTime = vtime
+	t.Cleanup(func() { sim.UniversalTime = previous })
+
+	var err, childErr, childCause error
+	var afterFuncRan bool
+	start := vtime.Time
+	sim.Run(func() {
+		ctx, cancel := sim.WithTimeout(context.Background(), 5*sim.Second)
+		defer cancel()
+		child, cancelChild := context.WithCancel(ctx)
+		defer cancelChild()
+		err = sim.Wait(ctx)
+		childErr, childCause = child.Err(), context.Cause(child)
+
+		// AfterFunc on a canceled context spawns fn as a task, which may use the yield points.
+		ran := sim.NewChan[bool](0)
+		ctx.(interface{ AfterFunc(func()) func() bool }).AfterFunc(func() { ran.Send(true) })
+		afterFuncRan, _ = ran.Recv()
+	})
+	if err != context.DeadlineExceeded || childErr != context.DeadlineExceeded || childCause != context.DeadlineExceeded {
+		t.Fatalf("expected the deadline to be exceeded by the context and its child. got %v, %v and %v", err, childErr, childCause)
+	}
+	if !afterFuncRan {
+		t.Fatal("expected AfterFunc to run fn once the context is canceled")
+	}
+	if elapsed := vtime.Time - start; elapsed < 5*sim.Second || elapsed > 6*sim.Second {
+		t.Fatalf("expected Wait to skip to the deadline. got %d", elapsed)
+	}
+
+	ctx, cancel := sim.WithTimeout(context.Background(), sim.Hour)
+	child, cancelChild := context.WithCancel(ctx)
+	defer cancelChild()
+	cancel()
+	if sim.Wait(ctx) != context.Canceled || child.Err() != context.Canceled {
+		t.Fatalf("expected the context and its child to be canceled. got %v and %v", ctx.Err(), child.Err())
+	}
+}
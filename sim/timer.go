@@ -0,0 +1,522 @@
+package sim
+
+import (
+	"container/heap"
+	"sync"
+	stdtime "time"
+
+	"github.com/james-orcales/golang_snacks/invariant"
+)
+
+// Timer is the equivalent of stdtime.Timer for any Time. Like stdtime.Timer, C has a buffer of one
+// so that a slow receiver misses fires instead of blocking the clock. Inside of a simulation, only
+// Wait is a yield point. Blocking on C keeps the other tasks from running, which Run reports under
+// VirtualTime.
+type Timer struct {
+	C     <-chan stdtime.Time
+	stop  func() bool
+	reset func(Duration) bool
+	wait  func() (stdtime.Time, bool)
+}
+
+// Stop prevents the timer from firing. It returns false if the timer already fired or was
+// stopped.
+func (timer *Timer) Stop() bool {
+	return timer.stop()
+}
+
+// Reset changes the timer to fire after duration. It returns false if the timer already fired or
+// was stopped.
+func (timer *Timer) Reset(duration Duration) bool {
+	return timer.reset(duration)
+}
+
+// Wait receives from C. It returns false once the timer is stopped. Inside of a simulation, it is
+// a yield point that parks the task until the timer fires so that VirtualTime can skip straight
+// to the deadline once every task is blocked. Refer to VirtualTime.RunFor.
+func (timer *Timer) Wait() (stdtime.Time, bool) {
+	invariant.Always(timer.wait != nil, "Timer.Wait is not called on the timer of AfterFunc")
+	return timer.wait()
+}
+
+// Ticker is the equivalent of stdtime.Ticker for any Time.
+type Ticker struct {
+	C     <-chan stdtime.Time
+	stop  func()
+	reset func(Duration)
+	wait  func() (stdtime.Time, bool)
+}
+
+func (ticker *Ticker) Stop() {
+	ticker.stop()
+}
+
+// Reset stops the ticker and restarts it with period.
+func (ticker *Ticker) Reset(period Duration) {
+	ticker.reset(period)
+}
+
+// Wait receives the next tick like Timer.Wait. Periodic jobs stop when their ticker does:
+//
+//	for {
+//		if _, ok := ticker.Wait(); !ok {
+//			return
+//		}
+//		job()
+//	}
+func (ticker *Ticker) Wait() (stdtime.Time, bool) {
+	return ticker.wait()
+}
+
+// stopSignal is closed by Stop so that Wait returns, and renewed by Reset.
+type stopSignal struct {
+	mutex sync.Mutex
+	c     chan struct{}
+}
+
+func newStopSignal() *stopSignal {
+	return &stopSignal{c: make(chan struct{})}
+}
+
+func (signal *stopSignal) stop() {
+	signal.mutex.Lock()
+	defer signal.mutex.Unlock()
+	select {
+	case <-signal.c:
+	default:
+		close(signal.c)
+	}
+}
+
+func (signal *stopSignal) reset() {
+	signal.mutex.Lock()
+	defer signal.mutex.Unlock()
+	select {
+	case <-signal.c:
+		signal.c = make(chan struct{})
+	default:
+	}
+}
+
+func (signal *stopSignal) done() <-chan struct{} {
+	signal.mutex.Lock()
+	defer signal.mutex.Unlock()
+	return signal.c
+}
+
+// waitOrStop blocks until c receives or signal stops.
+func waitOrStop(c <-chan stdtime.Time, signal *stopSignal) (stdtime.Time, bool) {
+	select {
+	case now := <-c:
+		return now, true
+	case <-signal.done():
+		return stdtime.Time{}, false
+	}
+}
+
+func NewTimer(duration Duration) *Timer {
+	return UniversalTime.NewTimer(duration)
+}
+
+func NewTicker(period Duration) *Ticker {
+	return UniversalTime.NewTicker(period)
+}
+
+// After returns the channel of a new timer. Inside of a simulation, receiving from it blocks the
+// other tasks, so use Sleep or the Wait of NewTimer instead.
+func After(duration Duration) <-chan stdtime.Time {
+	return UniversalTime.After(duration)
+}
+
+func AfterFunc(duration Duration, fn func()) *Timer {
+	return UniversalTime.AfterFunc(duration, fn)
+}
+
+func (stime *SystemTime) NewTimer(duration Duration) *Timer {
+	timer := stdtime.NewTimer(stdtime.Duration(duration))
+	signal := newStopSignal()
+	return &Timer{
+		C: timer.C,
+		stop: func() bool {
+			signal.stop()
+			return timer.Stop()
+		},
+		reset: func(duration Duration) bool {
+			signal.reset()
+			return timer.Reset(stdtime.Duration(duration))
+		},
+		wait: func() (stdtime.Time, bool) {
+			return waitOrStop(timer.C, signal)
+		},
+	}
+}
+
+func (stime *SystemTime) NewTicker(period Duration) *Ticker {
+	ticker := stdtime.NewTicker(stdtime.Duration(period))
+	signal := newStopSignal()
+	return &Ticker{
+		C: ticker.C,
+		stop: func() {
+			signal.stop()
+			ticker.Stop()
+		},
+		reset: func(period Duration) {
+			signal.reset()
+			ticker.Reset(stdtime.Duration(period))
+		},
+		wait: func() (stdtime.Time, bool) {
+			return waitOrStop(ticker.C, signal)
+		},
+	}
+}
+
+func (stime *SystemTime) After(duration Duration) <-chan stdtime.Time {
+	return stdtime.After(stdtime.Duration(duration))
+}
+
+func (stime *SystemTime) AfterFunc(duration Duration, fn func()) *Timer {
+	timer := stdtime.AfterFunc(stdtime.Duration(duration), fn)
+	return &Timer{
+		stop: timer.Stop,
+		reset: func(duration Duration) bool {
+			return timer.Reset(stdtime.Duration(duration))
+		},
+	}
+}
+
+// virtualTimer is pending in VirtualTime.timers until VirtualTime.Time reaches its deadline.
+type virtualTimer struct {
+	deadline Moment
+	// sequence orders timers with the same deadline by creation.
+	sequence uint64
+	// period is positive for tickers.
+	period Duration
+	// index is the position in VirtualTime.timers, or -1 if the timer isn't pending.
+	index  int
+	c      chan stdtime.Time
+	fn     func()
+	signal *stopSignal
+	// waiters are the tasks in Wait, guarded by the mutex of the simulation.
+	waiters []*task
+}
+
+type virtualTimers []*virtualTimer
+
+func (timers virtualTimers) Len() int { return len(timers) }
+
+func (timers virtualTimers) Less(i, j int) bool {
+	if timers[i].deadline != timers[j].deadline {
+		return timers[i].deadline < timers[j].deadline
+	}
+	return timers[i].sequence < timers[j].sequence
+}
+
+func (timers virtualTimers) Swap(i, j int) {
+	timers[i], timers[j] = timers[j], timers[i]
+	timers[i].index = i
+	timers[j].index = j
+}
+
+func (timers *virtualTimers) Push(x any) {
+	timer := x.(*virtualTimer)
+	timer.index = len(*timers)
+	*timers = append(*timers, timer)
+}
+
+func (timers *virtualTimers) Pop() any {
+	old := *timers
+	timer := old[len(old)-1]
+	old[len(old)-1] = nil
+	timer.index = -1
+	*timers = old[:len(old)-1]
+	return timer
+}
+
+// schedule must be called while holding the mutex.
+func (vtime *VirtualTime) schedule(timer *virtualTimer, duration Duration) {
+	if timer.index >= 0 {
+		heap.Remove(&vtime.timers, timer.index)
+	}
+	vtime.timerSequence++
+	timer.sequence = vtime.timerSequence
+	timer.deadline = vtime.Time.Advance(max(duration, 0))
+	heap.Push(&vtime.timers, timer)
+}
+
+// unschedule must be called while holding the mutex.
+func (vtime *VirtualTime) unschedule(timer *virtualTimer) (wasPending bool) {
+	if timer.index < 0 {
+		return false
+	}
+	heap.Remove(&vtime.timers, timer.index)
+	return true
+}
+
+// expireTimers removes the timers whose deadline passed, in the order of their deadlines. Tickers
+// are rescheduled after the current time, skipping the ticks that were missed like
+// stdtime.Ticker does for slow receivers. It must be called while holding the mutex.
+func (vtime *VirtualTime) expireTimers() (expired []*virtualTimer) {
+	for len(vtime.timers) > 0 && vtime.timers[0].deadline <= vtime.Time {
+		timer := vtime.timers[0]
+		expired = append(expired, timer)
+		if timer.period > 0 {
+			missed := Duration(vtime.Time-timer.deadline) / timer.period
+			timer.deadline = timer.deadline.Advance((missed + 1) * timer.period)
+			heap.Fix(&vtime.timers, 0)
+		} else {
+			heap.Pop(&vtime.timers)
+		}
+	}
+	return expired
+}
+
+// fireTimers delivers the timers returned by expireTimers after the mutex is released.
+func (vtime *VirtualTime) fireTimers(expired []*virtualTimer, now Moment) {
+	if len(expired) == 0 {
+		return
+	}
+	s := simulation.Load()
+	if s == nil {
+		deliverTimers(nil, expired, now)
+		return
+	}
+	s.mutex.Lock()
+	deliverTimers(s, expired, now)
+	s.mutex.Unlock()
+}
+
+// deliverTimers sends now on the channels of the timers and wakes the tasks that wait on them.
+// Functions of AfterFunc run in their own task, or goroutine outside of a simulation. It must be
+// called while holding the mutex of s, if any.
+func deliverTimers(s *scheduler, expired []*virtualTimer, now Moment) {
+	for _, timer := range expired {
+		switch {
+		case timer.fn != nil && s != nil:
+			s.spawn(timer.fn)
+		case timer.fn != nil:
+			go timer.fn()
+		default:
+			select {
+			case timer.c <- now.StdTime():
+			default:
+			}
+			if s != nil {
+				s.wake(&timer.waiters)
+			}
+		}
+	}
+}
+
+// NewTimer fires once Time passes the deadline, which only happens as the simulation advances.
+func (vtime *VirtualTime) NewTimer(duration Duration) *Timer {
+	timer := &virtualTimer{c: make(chan stdtime.Time, 1), index: -1, signal: newStopSignal()}
+	vtime.Mutex.Lock()
+	vtime.schedule(timer, duration)
+	vtime.Mutex.Unlock()
+	return &Timer{
+		C: timer.c,
+		stop: func() bool {
+			return vtime.stopTimer(timer)
+		},
+		reset: func(duration Duration) bool {
+			timer.signal.reset()
+			vtime.Mutex.Lock()
+			defer vtime.Mutex.Unlock()
+			wasPending := timer.index >= 0
+			vtime.schedule(timer, duration)
+			return wasPending
+		},
+		wait: func() (stdtime.Time, bool) {
+			return waitTimer(timer)
+		},
+	}
+}
+
+func (vtime *VirtualTime) AfterFunc(duration Duration, fn func()) *Timer {
+	invariant.Always(fn != nil, "VirtualTime.AfterFunc has a function")
+	timer := &virtualTimer{fn: fn, index: -1}
+	vtime.Mutex.Lock()
+	vtime.schedule(timer, duration)
+	vtime.Mutex.Unlock()
+	return &Timer{
+		stop: func() bool {
+			vtime.Mutex.Lock()
+			defer vtime.Mutex.Unlock()
+			return vtime.unschedule(timer)
+		},
+		reset: func(duration Duration) bool {
+			vtime.Mutex.Lock()
+			defer vtime.Mutex.Unlock()
+			wasPending := timer.index >= 0
+			vtime.schedule(timer, duration)
+			return wasPending
+		},
+	}
+}
+
+func (vtime *VirtualTime) After(duration Duration) <-chan stdtime.Time {
+	return vtime.NewTimer(duration).C
+}
+
+func (vtime *VirtualTime) NewTicker(period Duration) *Ticker {
+	invariant.Always(period > 0, "VirtualTime.NewTicker period is positive")
+	timer := &virtualTimer{c: make(chan stdtime.Time, 1), period: period, index: -1, signal: newStopSignal()}
+	vtime.Mutex.Lock()
+	vtime.schedule(timer, period)
+	vtime.Mutex.Unlock()
+	return &Ticker{
+		C: timer.c,
+		stop: func() {
+			vtime.stopTimer(timer)
+		},
+		reset: func(period Duration) {
+			invariant.Always(period > 0, "Ticker.Reset period is positive")
+			timer.signal.reset()
+			vtime.Mutex.Lock()
+			defer vtime.Mutex.Unlock()
+			timer.period = period
+			vtime.schedule(timer, period)
+		},
+		wait: func() (stdtime.Time, bool) {
+			return waitTimer(timer)
+		},
+	}
+}
+
+// stopTimer unschedules the timer and releases the tasks that wait on it.
+func (vtime *VirtualTime) stopTimer(timer *virtualTimer) (wasPending bool) {
+	vtime.Mutex.Lock()
+	wasPending = vtime.unschedule(timer)
+	vtime.Mutex.Unlock()
+	timer.signal.stop()
+	if s := simulation.Load(); s != nil {
+		s.mutex.Lock()
+		s.wake(&timer.waiters)
+		s.mutex.Unlock()
+	}
+	return wasPending
+}
+
+func waitTimer(timer *virtualTimer) (stdtime.Time, bool) {
+	s := simulation.Load()
+	if s == nil {
+		return waitOrStop(timer.c, timer.signal)
+	}
+	Yield()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for {
+		select {
+		case now := <-timer.c:
+			return now, true
+		case <-timer.signal.done():
+			return stdtime.Time{}, false
+		default:
+		}
+		s.park(&timer.waiters)
+		s.mutex.Lock()
+	}
+}
+
+// AdvanceToNext jumps Time to the earliest deadline of the pending timers and fires the timers
+// that are due. It returns false without advancing if no timer is pending.
+func (vtime *VirtualTime) AdvanceToNext() bool {
+	vtime.Mutex.Lock()
+	if len(vtime.timers) == 0 {
+		vtime.Mutex.Unlock()
+		return false
+	}
+	vtime.jump(vtime.timers[0].deadline)
+	expired, now := vtime.expireTimers(), vtime.Time
+	vtime.Mutex.Unlock()
+	vtime.fireTimers(expired, now)
+	return true
+}
+
+// RunUntilIdle fires the timers that are due and lets the other tasks of the simulation run
+// until every one of them is blocked. It doesn't advance Time by itself. Outside of a
+// simulation, goroutines can't be tracked, so it is runtime.Gosched.
+func (vtime *VirtualTime) RunUntilIdle() {
+	vtime.Mutex.Lock()
+	expired, now := vtime.expireTimers(), vtime.Time
+	vtime.Mutex.Unlock()
+	vtime.fireTimers(expired, now)
+
+	s := simulation.Load()
+	if s == nil {
+		Yield()
+		return
+	}
+	s.mutex.Lock()
+	s.park(&s.idle)
+}
+
+// RunFor simulates duration as fast as the tasks allow. Whenever every other task is blocked,
+// Time jumps to the next deadline of the pending timers until it reaches the end. A week of
+// periodic jobs takes milliseconds:
+//
+//	vtime := sim.NewVirtualTime(nil)
+//	sim.UniversalTime = vtime
+//	sim.Run(func() {
+//		ticker := sim.NewTicker(sim.Hour)
+//		sim.Go(func() {
+//			for {
+//				if _, ok := ticker.Wait(); !ok {
+//					return
+//				}
+//				compactDatabase()
+//			}
+//		})
+//		vtime.RunFor(sim.Week)
+//		ticker.Stop()
+//	})
+//
+// Without a driver, a simulation whose tasks are all blocked jumps to the next timer that would
+// wake one of them.
+func (vtime *VirtualTime) RunFor(duration Duration) {
+	invariant.Always(duration >= 0, "VirtualTime.RunFor duration is non-negative")
+	vtime.Mutex.Lock()
+	end := vtime.Time.Advance(duration)
+	vtime.Mutex.Unlock()
+	for {
+		vtime.RunUntilIdle()
+		vtime.Mutex.Lock()
+		if len(vtime.timers) == 0 || vtime.timers[0].deadline > end {
+			break
+		}
+		vtime.Mutex.Unlock()
+		vtime.AdvanceToNext()
+	}
+	vtime.jump(end)
+	vtime.Mutex.Unlock()
+	vtime.RunUntilIdle()
+}
+
+// jump moves Time forward to until without simulating the passage of time in between, so that
+// the clock neither drifts nor syncs. It must be called while holding the mutex.
+func (vtime *VirtualTime) jump(until Moment) {
+	if until > vtime.Time {
+		vtime.Time = until
+	}
+}
+
+// advanceIdle jumps Time to the earliest timer that wakes a task of s and fires every timer up to
+// it. It returns false if no timer would. It must be called while holding the mutex of s.
+func (vtime *VirtualTime) advanceIdle(s *scheduler) bool {
+	vtime.Mutex.Lock()
+	next, found := Moment(0), false
+	for _, timer := range vtime.timers {
+		if (timer.fn != nil || len(timer.waiters) > 0) && (!found || timer.deadline < next) {
+			next, found = timer.deadline, true
+		}
+	}
+	if !found {
+		vtime.Mutex.Unlock()
+		return false
+	}
+	vtime.jump(next)
+	expired, now := vtime.expireTimers(), vtime.Time
+	vtime.Mutex.Unlock()
+	deliverTimers(s, expired, now)
+	return true
+}
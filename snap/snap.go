@@ -9,6 +9,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/james-orcales/golang_snacks/internal/term"
 	"github.com/james-orcales/golang_snacks/myers"
 	"github.com/james-orcales/golang_snacks/xdebug"
 )
@@ -164,9 +165,9 @@ func (snapshot Snapshot) IsEqual(actual string) (isEqual bool) {
 				}
 				switch line[0] {
 				case '+':
-					fmt.Println("\033[32m" + line + "\033[0m")
+					fmt.Println(term.Green + line + term.Reset)
 				case '-':
-					fmt.Println("\033[31m" + line + "\033[0m")
+					fmt.Println(term.Red + line + term.Reset)
 				default:
 					fmt.Println(line)
 				}